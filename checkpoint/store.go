@@ -0,0 +1,185 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/metadata"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Pipeline is the handler name of the checkpoint of a whole pipeline, as
+// opposed to the checkpoint of one of its handlers.
+const Pipeline = ""
+
+// ErrConflict is returned when advancing a checkpoint to a block at or before
+// the one it is at, such as when another process advanced it meanwhile.
+var ErrConflict = errors.New("checkpoint conflict")
+
+// Store reads and writes the checkpoints of a pipeline in the checkpoints
+// metadata table.
+//
+// A checkpoint advances atomically with the destination writes of its block
+// only when the metadata and destination tables are in the same database, so
+// that utils.Deps.Transaction uses a single transaction for both. Otherwise
+// the destination commits first, and a failure before the checkpoint commits
+// processes the block again: its handlers should then write idempotently.
+type Store struct {
+	db       *gorm.DB
+	project  string
+	pipeline string
+}
+
+func NewStore(db *gorm.DB, project string, pipeline string) *Store {
+	return &Store{db: db, project: project, pipeline: pipeline}
+}
+
+// WithTx returns a store writing through tx, so that checkpoints advance in
+// the same transaction as the destination writes of a block when metadata
+// and destination share a database, see Store.
+func (s *Store) WithTx(tx *gorm.DB) *Store {
+	return &Store{db: tx, project: s.project, pipeline: s.pipeline}
+}
+
+// Get returns the checkpoint of handler, Pipeline for the pipeline one, or nil
+// if nothing was processed yet.
+func (s *Store) Get(ctx context.Context, handler string) (*metadata.Checkpoint, error) {
+	var c metadata.Checkpoint
+	err := s.db.WithContext(ctx).
+		Where("project = ? AND pipeline = ? AND handler = ?", s.project, s.pipeline, handler).
+		Take(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// List returns the checkpoints of the pipeline and its handlers.
+func (s *Store) List(ctx context.Context) ([]metadata.Checkpoint, error) {
+	var checkpoints []metadata.Checkpoint
+	err := s.db.WithContext(ctx).
+		Where("project = ? AND pipeline = ?", s.project, s.pipeline).
+		Order("handler").
+		Find(&checkpoints).Error
+	return checkpoints, err
+}
+
+// ListProject returns the checkpoints of every pipeline of a project, for
+// operators to see where each pipeline is.
+func ListProject(ctx context.Context, db *gorm.DB, project string) ([]metadata.Checkpoint, error) {
+	var checkpoints []metadata.Checkpoint
+	err := db.WithContext(ctx).
+		Where("project = ?", project).
+		Order("pipeline, handler").
+		Find(&checkpoints).Error
+	return checkpoints, err
+}
+
+// Advance records block as the last block processed by handler. Checkpoints
+// only move forward, it returns ErrConflict otherwise; use Reset to move one
+// back. The checkpoint row is locked until the store's transaction ends, so
+// that concurrent advances of the same checkpoint conflict.
+func (s *Store) Advance(ctx context.Context, handler string, block *ethereum.Block) error {
+	var current metadata.Checkpoint
+	result := s.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("project = ? AND pipeline = ? AND handler = ?", s.project, s.pipeline, handler).
+		Limit(1).
+		Find(&current)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 && block.Number <= current.BlockNumber {
+		return fmt.Errorf("%w: %s of %s/%s is at block %d, cannot advance to %d",
+			ErrConflict, handlerName(handler), s.project, s.pipeline, current.BlockNumber, block.Number)
+	}
+	return s.save(ctx, handler, block.Number, block.Hash)
+}
+
+// Rewind moves the checkpoint of handler back to block, after a chain
+// reorganization orphaned the blocks after it.
+func (s *Store) Rewind(ctx context.Context, handler string, block *ethereum.Block) error {
+	return s.save(ctx, handler, block.Number, block.Hash)
+}
+
+// Reset moves the checkpoint of handler to block number, so that processing
+// resumes at the next block. The block hash is unknown after a reset and is
+// recorded as empty.
+func (s *Store) Reset(ctx context.Context, handler string, number int64) error {
+	return s.save(ctx, handler, number, "")
+}
+
+// Delete removes the checkpoint of handler, so that processing starts over
+// from the start block.
+func (s *Store) Delete(ctx context.Context, handler string) error {
+	return s.db.WithContext(ctx).
+		Where("project = ? AND pipeline = ? AND handler = ?", s.project, s.pipeline, handler).
+		Delete(&metadata.Checkpoint{}).Error
+}
+
+func (s *Store) save(ctx context.Context, handler string, number int64, hash string) error {
+	c := metadata.Checkpoint{
+		Project:     s.project,
+		Pipeline:    s.pipeline,
+		Handler:     handler,
+		BlockNumber: number,
+		BlockHash:   hash,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	// Save would insert without ON CONFLICT for the pipeline checkpoint,
+	// whose handler is empty.
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&c).Error
+}
+
+// Cursor returns the checkpoint of handler as an executor.Checkpoints.
+func (s *Store) Cursor(handler string) *Cursor {
+	return &Cursor{store: s, handler: handler}
+}
+
+// Cursor is one checkpoint of a Store.
+type Cursor struct {
+	store   *Store
+	handler string
+}
+
+func (c *Cursor) Last(ctx context.Context) (int64, bool, error) {
+	checkpoint, err := c.store.Get(ctx, c.handler)
+	if err != nil || checkpoint == nil {
+		return 0, false, err
+	}
+	return checkpoint.BlockNumber, true, nil
+}
+
+// Advance advances the checkpoint through metadataDB, the metadata
+// transaction of the block, or through the store's db if it is nil.
+func (c *Cursor) Advance(ctx context.Context, metadataDB *gorm.DB, block *ethereum.Block) error {
+	store := c.store
+	if metadataDB != nil {
+		store = store.WithTx(metadataDB)
+	}
+	return store.Advance(ctx, c.handler, block)
+}
+
+// Rewind rewinds the checkpoint through metadataDB like Advance.
+func (c *Cursor) Rewind(ctx context.Context, metadataDB *gorm.DB, block *ethereum.Block) error {
+	store := c.store
+	if metadataDB != nil {
+		store = store.WithTx(metadataDB)
+	}
+	return store.Rewind(ctx, c.handler, block)
+}
+
+func handlerName(handler string) string {
+	if handler == Pipeline {
+		return "pipeline"
+	}
+	return handler
+}
@@ -0,0 +1,140 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+)
+
+var checkpointColumns = []string{"project", "pipeline", "handler", "block_number", "block_hash"}
+
+func TestStoreGet(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(db.DB, "project", "pipeline")
+	ctx := context.Background()
+
+	c, err := s.Get(ctx, Pipeline)
+	if err != nil || c != nil {
+		t.Fatalf("Get() of a new pipeline = %v, %v, want nil", c, err)
+	}
+	if last, ok, err := s.Cursor(Pipeline).Last(ctx); err != nil || ok {
+		t.Errorf("Last() of a new pipeline = %d, %t, %v, want not found", last, ok, err)
+	}
+
+	db.Rows(`"checkpoints"`, checkpointColumns, []any{"project", "pipeline", "HandleTransfer", 12, "0x0c"})
+	c, err = s.Get(ctx, "HandleTransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.BlockNumber != 12 || c.BlockHash != "0x0c" {
+		t.Errorf("Get() = %+v, want block 12 0x0c", c)
+	}
+	if q := db.Statements(`"checkpoints"`)[2]; !equalArgs(q.Args, "project", "pipeline", "HandleTransfer") {
+		t.Errorf("Get() queried %s %v", q.SQL, q.Args)
+	}
+	if last, ok, err := s.Cursor("HandleTransfer").Last(ctx); err != nil || !ok || last != 12 {
+		t.Errorf("Last() = %d, %t, %v, want 12", last, ok, err)
+	}
+}
+
+func TestStoreAdvance(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(db.DB, "project", "pipeline")
+	ctx := context.Background()
+
+	if err := s.Advance(ctx, Pipeline, &ethereum.Block{Number: 5, Hash: "0x05"}); err != nil {
+		t.Fatal(err)
+	}
+	read := db.Statements(`SELECT * FROM "checkpoints"`)
+	if len(read) != 1 || !strings.HasSuffix(read[0].SQL, "FOR UPDATE") {
+		t.Errorf("Advance() read %v, want the checkpoint locked", read)
+	}
+	saved := db.Statements(`INSERT INTO "checkpoints"`)
+	if len(saved) != 1 || saved[0].Args[3] != int64(5) || saved[0].Args[4] != "0x05" || !strings.Contains(saved[0].SQL, "ON CONFLICT") {
+		t.Fatalf("Advance() saved %v, want block 5 0x05", saved)
+	}
+
+	db.Reset()
+	db.Rows(`"checkpoints"`, checkpointColumns, []any{"project", "pipeline", "", 5, "0x05"})
+	if err := s.Advance(ctx, Pipeline, &ethereum.Block{Number: 6, Hash: "0x06"}); err != nil {
+		t.Fatal(err)
+	}
+	if saved := db.Statements(`INSERT INTO "checkpoints"`); len(saved) != 1 || saved[0].Args[3] != int64(6) {
+		t.Errorf("Advance() saved %v, want block 6", saved)
+	}
+}
+
+func TestStoreAdvanceConflict(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Rows(`"checkpoints"`, checkpointColumns, []any{"project", "pipeline", "", 5, "0x05"})
+	s := NewStore(db.DB, "project", "pipeline")
+	ctx := context.Background()
+
+	for _, number := range []int64{4, 5} {
+		err := s.Advance(ctx, Pipeline, &ethereum.Block{Number: number})
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("Advance(%d) = %v, want ErrConflict", number, err)
+		}
+	}
+	if saved := db.Statements(`INSERT INTO "checkpoints"`); len(saved) != 0 {
+		t.Errorf("Advance() saved %v after a conflict", saved)
+	}
+
+	// Rewind and Reset move the checkpoint back.
+	if err := s.Rewind(ctx, Pipeline, &ethereum.Block{Number: 3, Hash: "0x03"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reset(ctx, Pipeline, 2); err != nil {
+		t.Fatal(err)
+	}
+	saved := db.Statements(`INSERT INTO "checkpoints"`)
+	if len(saved) != 2 || saved[0].Args[3] != int64(3) || saved[1].Args[3] != int64(2) || saved[1].Args[4] != "" {
+		t.Errorf("Rewind() and Reset() saved %v, want blocks 3 and 2", saved)
+	}
+}
+
+func TestCursorAdvanceTx(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewStore(db.DB, "project", "pipeline").Cursor("HandleTransfer")
+	if err := c.Advance(context.Background(), tx.DB, &ethereum.Block{Number: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(db.Statements(`"checkpoints"`)); n != 0 {
+		t.Errorf("Advance() ran %d statements on the store db, want 0", n)
+	}
+	if n := len(tx.Statements(`INSERT INTO "checkpoints"`)); n != 1 {
+		t.Errorf("Advance() saved %d checkpoints through the transaction, want 1", n)
+	}
+}
+
+func equalArgs(args []any, want ...any) bool {
+	if len(args) < len(want) {
+		return false
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
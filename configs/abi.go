@@ -0,0 +1,239 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	ErrEventNotFound          = errors.New("event not found in abi")
+	ErrEventSignatureMismatch = errors.New("event signature does not match abi")
+	ErrAmbiguousEvent         = errors.New("event name is overloaded in abi")
+)
+
+// GetPluginsDir returns the directory, relative to the working directory, that
+// holds the project's plugins and their abis directory.
+func (c *Config) GetPluginsDir() string {
+	return fmt.Sprintf("plugins_%s", c.ProjectConfig.Name)
+}
+
+// LoadABIFile reads and parses a JSON ABI file.
+func LoadABIFile(path string) (abi.ABI, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return abi.ABI{}, err
+	}
+	defer file.Close()
+
+	contractAbi, err := abi.JSON(file)
+	if err != nil {
+		return abi.ABI{}, fmt.Errorf("parse abi %s: %w", filepath.Base(path), err)
+	}
+	return contractAbi, nil
+}
+
+// EventRef is the event an EventHandler refers to. It is either a bare name,
+// e.g. "Transfer", or a signature such as
+// "Transfer(indexed address,indexed address,uint256)". Params is nil for a
+// bare name.
+type EventRef struct {
+	Name   string
+	Params []EventParam
+}
+
+// EventParam is one parameter of an event signature.
+type EventParam struct {
+	Type    string
+	Indexed bool
+}
+
+// ParseEventRef parses the Event field of an EventHandler. Parameter names are
+// allowed and ignored, "indexed" may appear before or after the type.
+func ParseEventRef(s string) (EventRef, error) {
+	s = strings.TrimSpace(s)
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		if s == "" {
+			return EventRef{}, errors.New("empty event reference")
+		}
+		return EventRef{Name: s}, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return EventRef{}, fmt.Errorf("malformed event signature %q", s)
+	}
+	ref := EventRef{Name: strings.TrimSpace(s[:open]), Params: []EventParam{}}
+	if ref.Name == "" {
+		return EventRef{}, fmt.Errorf("malformed event signature %q", s)
+	}
+	inner := strings.TrimSpace(s[open+1 : len(s)-1])
+	if inner == "" {
+		return ref, nil
+	}
+	for _, part := range splitTopLevel(inner, ',') {
+		var param EventParam
+		for _, token := range splitTopLevel(strings.TrimSpace(part), ' ') {
+			switch {
+			case token == "":
+			case token == "indexed":
+				param.Indexed = true
+			case param.Type == "":
+				param.Type = canonicalType(token)
+			}
+		}
+		if param.Type == "" {
+			return EventRef{}, fmt.Errorf("malformed event signature %q", s)
+		}
+		ref.Params = append(ref.Params, param)
+	}
+	return ref, nil
+}
+
+// Sig returns the canonical signature of the ref, e.g.
+// "Transfer(address,address,uint256)", or the bare name if it has no params.
+func (r EventRef) Sig() string {
+	if r.Params == nil {
+		return r.Name
+	}
+	types := make([]string, 0, len(r.Params))
+	for _, p := range r.Params {
+		types = append(types, p.Type)
+	}
+	return fmt.Sprintf("%s(%s)", r.Name, strings.Join(types, ","))
+}
+
+// hasIndexed reports whether the ref marks any parameter as indexed, in which
+// case the indexed flags are expected to match the abi exactly.
+func (r EventRef) hasIndexed() bool {
+	for _, p := range r.Params {
+		if p.Indexed {
+			return true
+		}
+	}
+	return false
+}
+
+// FunctionSelector returns the 4-byte selector of a function signature such as
+// "transfer(address to, uint amount)" as a 0x-prefixed hex string. Parameter
+// names are ignored and int/uint aliases are expanded.
+func FunctionSelector(signature string) (string, error) {
+	ref, err := ParseEventRef(signature)
+	if err != nil {
+		return "", err
+	}
+	if ref.Params == nil {
+		return "", fmt.Errorf("function %q should be a full signature, e.g. %s(address,uint256)", signature, ref.Name)
+	}
+	if ref.hasIndexed() {
+		return "", fmt.Errorf("function %q should not have indexed parameters", signature)
+	}
+	return hexutil.Encode(crypto.Keccak256([]byte(ref.Sig()))[:4]), nil
+}
+
+// FindEvent resolves an EventHandler event reference against an ABI. A bare
+// name matching several overloaded events returns ErrAmbiguousEvent; a
+// signature whose name exists but whose types or indexed flags differ returns
+// ErrEventSignatureMismatch.
+func FindEvent(contractAbi abi.ABI, event string) (*abi.Event, error) {
+	ref, err := ParseEventRef(event)
+	if err != nil {
+		return nil, err
+	}
+	candidates := eventsByRawName(contractAbi, ref.Name)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEventNotFound, ref.Name)
+	}
+	if ref.Params == nil {
+		if len(candidates) > 1 {
+			sigs := make([]string, 0, len(candidates))
+			for _, c := range candidates {
+				sigs = append(sigs, c.Sig)
+			}
+			return nil, fmt.Errorf("%w: %s matches %s, use a full signature", ErrAmbiguousEvent, ref.Name, strings.Join(sigs, ", "))
+		}
+		return candidates[0], nil
+	}
+	sig := ref.Sig()
+	for _, c := range candidates {
+		if c.Sig != sig {
+			continue
+		}
+		if ref.hasIndexed() {
+			for i, input := range c.Inputs {
+				if input.Indexed != ref.Params[i].Indexed {
+					return nil, fmt.Errorf("%w: %s, abi has %s", ErrEventSignatureMismatch, event, c.String())
+				}
+			}
+		}
+		return c, nil
+	}
+	sigs := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		sigs = append(sigs, c.Sig)
+	}
+	return nil, fmt.Errorf("%w: %s, abi has %s", ErrEventSignatureMismatch, sig, strings.Join(sigs, ", "))
+}
+
+// eventsByRawName returns the events declared with name, including overloads
+// that go-ethereum renamed with a numeric suffix, in a stable order.
+func eventsByRawName(contractAbi abi.ABI, name string) []*abi.Event {
+	var events []*abi.Event
+	for key := range contractAbi.Events {
+		event := contractAbi.Events[key]
+		if event.RawName == name {
+			events = append(events, &event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	return events
+}
+
+// canonicalType expands the int/uint aliases the ABI spec allows, including
+// inside array and tuple types.
+func canonicalType(t string) string {
+	var b strings.Builder
+	for i := 0; i < len(t); {
+		j := i
+		for j < len(t) && (t[j] >= 'a' && t[j] <= 'z' || t[j] >= '0' && t[j] <= '9') {
+			j++
+		}
+		word := t[i:j]
+		b.WriteString(word)
+		if word == "int" || word == "uint" {
+			b.WriteString("256")
+		}
+		if j < len(t) {
+			b.WriteByte(t[j])
+			j++
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// splitTopLevel splits s on sep, ignoring separators nested in parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
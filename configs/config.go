@@ -1,9 +1,10 @@
 package configs
 
 import (
-	"errors"
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 )
 
 type SourceType string
@@ -16,6 +17,10 @@ const (
 type Config struct {
 	ProjectConfig  ProjectConfig
 	PipelineConfig PipelineConfig
+
+	// sources remembers the parsed YAML documents when the config was read by
+	// Load, so that validation problems can be reported with positions.
+	sources *sources
 }
 
 type ProjectConfig struct {
@@ -30,14 +35,18 @@ type ProjectConfig struct {
 
 // PipelineConfig https://zhwt.github.io/yaml-to-go/
 type PipelineConfig struct {
-	Name           string         `yaml:"name"`
-	Initialization Initialization `yaml:"initialization"`
-	Source         Source         `yaml:"source"`
-	Metadata       Metadata       `yaml:"metadata"`
-	Destination    Destination    `yaml:"destination"`
-	EventHandlers  []EventHandler `yaml:"eventHandlers"`
-	BlockHandlers  []BlockHandler `yaml:"blockHandlers"`
-	Templates      []Template     `yaml:"templates"`
+	Name             string            `yaml:"name"`
+	Initialization   Initialization    `yaml:"initialization"`
+	Source           Source            `yaml:"source"`
+	Metadata         Metadata          `yaml:"metadata"`
+	Destination      Destination       `yaml:"destination"`
+	EventHandlers    []EventHandler    `yaml:"eventHandlers"`
+	BlockHandlers    []BlockHandler    `yaml:"blockHandlers"`
+	CallHandlers     []CallHandler     `yaml:"callHandlers"`
+	IntervalHandlers []IntervalHandler `yaml:"intervalHandlers"`
+	Templates        []Template        `yaml:"templates"`
+	// Retry is the retry policy of every handler, see RetryPolicy.
+	Retry RetryPolicy `yaml:"retry"`
 }
 
 type Template struct {
@@ -52,13 +61,44 @@ type Initialization struct {
 }
 
 type Source struct {
-	Schema     string     `yaml:"schema"`
-	SourceDB   string     `yaml:"sourceDB"`
-	StartBlock int        `yaml:"startBlock"`
-	Addresses  []string   `yaml:"addresses"`
-	RPC        string     `yaml:"rpc"`
-	ABIFile    string     `yaml:"abiFile"`
-	Type       SourceType `yaml:"type"`
+	Schema     string `yaml:"schema"`
+	SourceDB   string `yaml:"sourceDB"`
+	StartBlock int    `yaml:"startBlock"`
+	// EndBlock is the last block to index, inclusive. 0 means no end.
+	EndBlock int `yaml:"endBlock"`
+	// Ranges lists disjoint block ranges to index, in ascending order. It
+	// replaces StartBlock and EndBlock when set.
+	Ranges    []BlockRange `yaml:"ranges"`
+	Addresses []string     `yaml:"addresses"`
+	RPC       string       `yaml:"rpc"`
+	ABIFile   string       `yaml:"abiFile"`
+	Type      SourceType   `yaml:"type"`
+	// Confirmations is how many blocks a block must be behind the latest
+	// source block before the pipeline processes it when following the
+	// chain head.
+	Confirmations int64 `yaml:"confirmations"`
+	// PollInterval is how often the source is checked for new blocks when
+	// following the chain head, 5s by default.
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// NotifyChannel is a Postgres channel the source db notifies on when it
+	// inserts blocks. When set, new blocks are picked up without waiting for
+	// the next poll.
+	NotifyChannel string `yaml:"notifyChannel"`
+	// ReorgDepth is how many of the last processed blocks are remembered to
+	// detect chain reorganizations, 64 by default. A reorg deeper than that
+	// stops the pipeline.
+	ReorgDepth int64 `yaml:"reorgDepth"`
+}
+
+// DefaultReorgDepth is the ReorgDepth of sources that do not set it.
+const DefaultReorgDepth = 64
+
+// GetReorgDepth returns ReorgDepth, or DefaultReorgDepth if it is not set.
+func (s *Source) GetReorgDepth() int64 {
+	if s.ReorgDepth == 0 {
+		return DefaultReorgDepth
+	}
+	return s.ReorgDepth
 }
 
 type Metadata struct {
@@ -69,82 +109,188 @@ type Metadata struct {
 type Destination struct {
 	DestinationDB string `yaml:"destinationDB"`
 	Schema        string `yaml:"schema"`
+	// Entities declares the destination tables zsource creates and migrates.
+	Entities []Entity `yaml:"entities"`
+	// AllowDestructiveMigrations lets entity migrations drop tables or
+	// columns and change column types or primary keys.
+	AllowDestructiveMigrations bool `yaml:"allowDestructiveMigrations"`
 }
 
 type EventHandler struct {
 	Event   string `yaml:"event"`
 	Handler string `yaml:"handler"`
+	// Filter restricts the logs passed to the handler by the values of indexed
+	// event arguments, keyed by argument name. Logs match when every listed
+	// argument has one of its values.
+	Filter map[string]FilterValues `yaml:"filter,omitempty"`
+	// Retry overrides the pipeline retry policy for this handler.
+	Retry *RetryPolicy `yaml:"retry,omitempty"`
+	// OrderIndependent declares that the handler does not depend on the
+	// writes of earlier blocks, which lets backfills run it concurrently
+	// across blocks. Such handlers must be idempotent. It is ignored for
+	// template handlers.
+	OrderIndependent bool `yaml:"orderIndependent"`
 }
 
 type BlockHandler struct {
 	Handler string `yaml:"handler"`
+	// Retry overrides the pipeline retry policy for this handler.
+	Retry *RetryPolicy `yaml:"retry,omitempty"`
+	// OrderIndependent is as for EventHandler.
+	OrderIndependent bool `yaml:"orderIndependent"`
+}
+
+// CallHandler routes calls of a contract function to a handler. Calls are
+// matched on the selector of Function, a full signature such as
+// "transfer(address,uint256)".
+type CallHandler struct {
+	Function string `yaml:"function"`
+	Handler  string `yaml:"handler"`
+	// To restricts the called contracts. When empty, Source.Addresses is used,
+	// and calls to any contract match if that is empty too.
+	To []string `yaml:"to,omitempty"`
+	// SuccessOnly skips reverted calls, including internal calls of reverted
+	// transactions.
+	SuccessOnly bool `yaml:"successOnly"`
+	// IncludeInternalCalls also dispatches calls made by contracts, read from
+	// the traces table, in addition to top level transactions.
+	IncludeInternalCalls bool `yaml:"includeInternalCalls"`
+	// Retry overrides the pipeline retry policy for this handler.
+	Retry *RetryPolicy `yaml:"retry,omitempty"`
+	// OrderIndependent is as for EventHandler.
+	OrderIndependent bool `yaml:"orderIndependent"`
 }
 
+// Validate checks the whole config and returns a ValidationErrors listing
+// every problem found, or nil if there are no error-severity problems.
 func (c *Config) Validate() error {
-	if err := c.ValidateProjectConfig(); err != nil {
-		return err
-	}
-	if err := c.ValidatePipelineConfig(); err != nil {
-		return err
-	}
-	return nil
+	return c.Check().Err()
+}
+
+// Check validates the whole config and returns all problems found, including
+// warnings. It applies the same defaults and normalization as Validate.
+func (c *Config) Check() ValidationErrors {
+	var errs ValidationErrors
+	c.checkProjectConfig(&errs)
+	c.checkPipelineConfig(&errs)
+	c.sources.annotate(errs)
+	return errs
 }
 
 func (c *Config) ValidateProjectConfig() error {
-	if c.ProjectConfig.Org == "" {
-		return errors.New("org should not be empty")
-	}
-	if c.ProjectConfig.Name == "" {
-		return errors.New("project name should not be empty")
-	}
-	if c.ProjectConfig.Kind == "" {
-		return errors.New("kind should not be empty")
-	}
-	if c.ProjectConfig.Network == "" {
-		return errors.New("network should not be empty")
-	}
-	return nil
+	var errs ValidationErrors
+	c.checkProjectConfig(&errs)
+	c.sources.annotate(errs)
+	return errs.Err()
+}
+
+func (c *Config) checkProjectConfig(errs *ValidationErrors) {
+	errs.required("project.org", c.ProjectConfig.Org, "org should not be empty")
+	errs.required("project.name", c.ProjectConfig.Name, "project name should not be empty")
+	errs.required("project.kind", c.ProjectConfig.Kind, "kind should not be empty")
+	errs.required("project.network", c.ProjectConfig.Network, "network should not be empty")
 }
 
 func (c *Config) ValidatePipelineConfig() error {
-	if c.PipelineConfig.Name == "" {
-		return errors.New("pipeline name should not be empty")
-	}
-	if c.PipelineConfig.Source.Schema == "" {
-		return errors.New("source db schema should not be empty")
+	var errs ValidationErrors
+	c.checkPipelineConfig(&errs)
+	c.sources.annotate(errs)
+	return errs.Err()
+}
+
+func (c *Config) checkPipelineConfig(errs *ValidationErrors) {
+	p := &c.PipelineConfig
+	errs.required("pipeline.name", p.Name, "pipeline name should not be empty")
+	if p.Source.Type == "" {
+		p.Source.Type = DB // source type is DB by default if not set
 	}
-	if c.PipelineConfig.Source.Type == "" {
-		c.PipelineConfig.Source.Type = DB // source type is DB by default if not set
+	switch p.Source.Type {
+	case DB:
+		errs.required("pipeline.source.schema", p.Source.Schema, "source db schema should not be empty")
+		errs.required("pipeline.source.sourceDB", p.Source.SourceDB, "source db should not be empty")
+	case RPC:
+		// Blocks come from the endpoint, so the source db and schema are
+		// optional; handlers may still query the source db when it is set.
+		errs.required("pipeline.source.rpc", p.Source.RPC, "source rpc url should not be empty")
+		if p.Source.RPC != "" {
+			if u, err := url.Parse(p.Source.RPC); err != nil || u.Scheme == "" || u.Host == "" {
+				errs.addError("pipeline.source.rpc", CodeInvalidValue, "source rpc %q is not a valid url", p.Source.RPC)
+			}
+		}
+	default:
+		errs.addError("pipeline.source.type", CodeInvalidValue, "source type %q should be %q or %q", p.Source.Type, DB, RPC)
 	}
 
-	if c.PipelineConfig.Source.SourceDB == "" {
-		return errors.New("source db should not be empty")
+	c.checkBlockRanges(errs)
+	if p.Source.Confirmations < 0 {
+		errs.addError("pipeline.source.confirmations", CodeInvalidValue, "source confirmations should not be negative")
 	}
-
-	if c.PipelineConfig.Source.StartBlock == 0 {
-		return errors.New("source startBlock should not be 0 or empty")
+	if p.Source.PollInterval < 0 {
+		errs.addError("pipeline.source.pollInterval", CodeInvalidValue, "source pollInterval should not be negative")
 	}
-
-	if c.PipelineConfig.Metadata.Schema == "" {
-		return errors.New("metadata db schema should not be empty")
+	if p.Source.ReorgDepth < 0 {
+		errs.addError("pipeline.source.reorgDepth", CodeInvalidValue, "source reorgDepth should not be negative")
 	}
 
-	if c.PipelineConfig.Metadata.MetadataDB == "" {
-		return errors.New("metadata db should not be empty")
+	errs.required("pipeline.metadata.schema", p.Metadata.Schema, "metadata db schema should not be empty")
+	errs.required("pipeline.metadata.metadataDB", p.Metadata.MetadataDB, "metadata db should not be empty")
+	errs.required("pipeline.destination.destinationDB", p.Destination.DestinationDB, "destination db should not be empty")
+	errs.required("pipeline.destination.schema", p.Destination.Schema, "destination db schema should not be empty")
+	checkEntities(errs, p.Destination.Entities)
+
+	for i, h := range p.EventHandlers {
+		checkEventHandler(errs, fmt.Sprintf("pipeline.eventHandlers[%d]", i), h)
 	}
-	if c.PipelineConfig.Destination.DestinationDB == "" {
-		return errors.New("destination db should not be empty")
+	for i, h := range p.BlockHandlers {
+		errs.required(fmt.Sprintf("pipeline.blockHandlers[%d].handler", i), h.Handler, "block handler name should not be empty")
+		checkRetryPolicy(errs, fmt.Sprintf("pipeline.blockHandlers[%d].retry", i), h.Retry)
 	}
-	if c.PipelineConfig.Destination.Schema == "" {
-		return errors.New("destination db schema should not be empty")
+	for i, h := range p.CallHandlers {
+		path := fmt.Sprintf("pipeline.callHandlers[%d]", i)
+		errs.required(path+".handler", h.Handler, "call handler name should not be empty")
+		if h.Function == "" {
+			errs.addError(path+".function", CodeRequired, "call handler function should not be empty")
+		} else if _, err := FunctionSelector(h.Function); err != nil {
+			errs.addError(path+".function", CodeInvalidValue, "%v", err)
+		}
+		for j, address := range h.To {
+			h.To[j] = strings.ToLower(address)
+		}
+		checkRetryPolicy(errs, path+".retry", h.Retry)
+	}
+	for i, h := range p.IntervalHandlers {
+		checkIntervalHandler(errs, fmt.Sprintf("pipeline.intervalHandlers[%d]", i), h)
+	}
+	for i, t := range p.Templates {
+		path := fmt.Sprintf("pipeline.templates[%d]", i)
+		errs.required(path+".name", t.Name, "template name should not be empty")
+		for j, h := range t.EventHandlers {
+			checkEventHandler(errs, fmt.Sprintf("%s.eventHandlers[%d]", path, j), h)
+		}
+	}
+	checkRetryPolicy(errs, "pipeline.retry", &p.Retry)
+	if len(p.EventHandlers) == 0 && len(p.BlockHandlers) == 0 && len(p.CallHandlers) == 0 && len(p.IntervalHandlers) == 0 && len(p.Templates) == 0 {
+		errs.addWarning("pipeline", CodeNoHandlers, "pipeline has no event, block, call, interval or template handlers")
 	}
 
 	var lowercaseAddresses []string
-	for _, address := range c.PipelineConfig.Source.Addresses {
+	for _, address := range p.Source.Addresses {
 		lowercaseAddresses = append(lowercaseAddresses, strings.ToLower(address))
 	}
-	c.PipelineConfig.Source.Addresses = lowercaseAddresses
-	return nil
+	p.Source.Addresses = lowercaseAddresses
+}
+
+func checkEventHandler(errs *ValidationErrors, path string, h EventHandler) {
+	errs.required(path+".event", h.Event, "event handler event should not be empty")
+	errs.required(path+".handler", h.Handler, "event handler name should not be empty")
+	for name, values := range h.Filter {
+		if name == "" {
+			errs.addError(path+".filter", CodeInvalidFilter, "filter argument name should not be empty")
+		} else if len(values) == 0 {
+			errs.addError(path+".filter."+name, CodeInvalidFilter, "filter on %s should list at least one value", name)
+		}
+	}
+	checkRetryPolicy(errs, path+".retry", h.Retry)
 }
 
 func (c *Config) GetChain() string {
@@ -174,3 +320,7 @@ func (c *Config) GetEnvironment() string {
 func (c *Config) GetSourceSchema() string {
 	return c.PipelineConfig.Source.Schema
 }
+
+func (c *Config) GetSourceType() SourceType {
+	return c.PipelineConfig.Source.Type
+}
@@ -0,0 +1,70 @@
+package configs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateRPCSource(t *testing.T) {
+	c := &Config{
+		ProjectConfig: ProjectConfig{Org: "org", Name: "name", Kind: "ethereum", Network: "mainnet"},
+		PipelineConfig: PipelineConfig{
+			Name:          "pipeline",
+			Source:        Source{Type: RPC, RPC: "http://localhost:8545", StartBlock: 1},
+			Metadata:      Metadata{Schema: "metadata", MetadataDB: "db"},
+			Destination:   Destination{Schema: "dest", DestinationDB: "db"},
+			BlockHandlers: []BlockHandler{{Handler: "HandleBlock"}},
+		},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("rpc source without source db should be valid: %v", err)
+	}
+
+	c.PipelineConfig.Source.RPC = "localhost"
+	var errs ValidationErrors
+	if err := c.Validate(); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "pipeline.source.rpc" {
+		t.Errorf("got %v, want an invalid pipeline.source.rpc", err)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var p PipelineConfig
+	err := yaml.Unmarshal([]byte(`
+retry:
+  maxAttempts: 5
+  initialBackoff: 500ms
+blockHandlers:
+  - handler: HandleBlock
+    retry:
+      maxBackoff: 2s
+      deadLetter: true
+intervalHandlers:
+  - handler: HandleHourly
+    every: hour
+    retry:
+      maxAttempts: 2
+      deadLetter: true
+`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{PipelineConfig: p}
+	policy := c.RetryPolicy("HandleBlock")
+	if policy.Attempts() != 5 || !policy.DeadLetters() {
+		t.Errorf("policy = %+v", policy)
+	}
+	for attempt, want := range map[int]time.Duration{1: 500 * time.Millisecond, 2: time.Second, 3: 2 * time.Second, 10: 2 * time.Second} {
+		if got := policy.Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+	if hourly := c.RetryPolicy("HandleHourly"); hourly.Attempts() != 2 || !hourly.DeadLetters() {
+		t.Errorf("interval handler policy = %+v", hourly)
+	}
+	if other := c.RetryPolicy("Other"); other.DeadLetters() || other.Backoff(10) != DefaultMaxBackoff {
+		t.Errorf("pipeline policy = %+v", other)
+	}
+}
@@ -0,0 +1,134 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// Codes reported by the deep validation pass.
+const (
+	CodeABINotFound            = "abi_not_found"
+	CodeABIInvalid             = "abi_invalid"
+	CodeEventNotFound          = "event_not_found"
+	CodeEventSignatureMismatch = "event_signature_mismatch"
+	CodeAmbiguousEvent         = "ambiguous_event"
+	CodeDuplicateHandler       = "duplicate_handler"
+	CodeDuplicateTemplate      = "duplicate_template"
+	CodeInvalidAddress         = "invalid_address"
+	CodeMissingABI             = "missing_abi"
+)
+
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// ValidateDeep runs Validate and then cross-checks the pipeline against the
+// ABIs in pluginsDir/abis, the same layout Deps.LoadABIByName reads from. An
+// empty pluginsDir means GetPluginsDir.
+func (c *Config) ValidateDeep(pluginsDir string) error {
+	return c.CheckDeep(pluginsDir).Err()
+}
+
+// CheckDeep is like Check but also verifies that ABI files exist and parse,
+// that every event handler names an event of its ABI, that template names and
+// handlers are unique and that addresses are well-formed.
+func (c *Config) CheckDeep(pluginsDir string) ValidationErrors {
+	if pluginsDir == "" {
+		pluginsDir = c.GetPluginsDir()
+	}
+	var errs ValidationErrors
+	c.checkProjectConfig(&errs)
+	c.checkPipelineConfig(&errs)
+
+	p := &c.PipelineConfig
+	checkAddresses(&errs, "pipeline.source.addresses", p.Source.Addresses)
+	sourceAbi := loadABIForCheck(&errs, pluginsDir, "pipeline.source.abiFile", p.Source.ABIFile)
+	checkEventHandlers(&errs, "pipeline.eventHandlers", p.EventHandlers, sourceAbi, p.Source.ABIFile != "")
+
+	for i, h := range p.CallHandlers {
+		checkAddresses(&errs, fmt.Sprintf("pipeline.callHandlers[%d].to", i), h.To)
+	}
+
+	names := make(map[string]int)
+	for i, t := range p.Templates {
+		path := fmt.Sprintf("pipeline.templates[%d]", i)
+		if first, ok := names[t.Name]; ok && t.Name != "" {
+			errs.addError(path+".name", CodeDuplicateTemplate, "template %s is already defined at pipeline.templates[%d]", t.Name, first)
+		} else {
+			names[t.Name] = i
+		}
+		checkAddresses(&errs, path+".addresses", t.Addresses)
+		templateAbi := loadABIForCheck(&errs, pluginsDir, path+".abiFile", t.ABIFile)
+		checkEventHandlers(&errs, path+".eventHandlers", t.EventHandlers, templateAbi, t.ABIFile != "")
+	}
+
+	c.sources.annotate(errs)
+	return errs
+}
+
+func checkAddresses(errs *ValidationErrors, path string, addresses []string) {
+	for i, address := range addresses {
+		if !addressPattern.MatchString(address) {
+			errs.addError(fmt.Sprintf("%s[%d]", path, i), CodeInvalidAddress, "address %q is not a 0x-prefixed 20-byte hex string", address)
+		}
+	}
+}
+
+// loadABIForCheck loads abiFile from pluginsDir/abis, recording a problem and
+// returning nil if it is missing or does not parse.
+func loadABIForCheck(errs *ValidationErrors, pluginsDir string, path string, abiFile string) *abi.ABI {
+	if abiFile == "" {
+		return nil
+	}
+	contractAbi, err := LoadABIFile(filepath.Join(pluginsDir, "abis", abiFile))
+	if err != nil {
+		code := CodeABIInvalid
+		if errors.Is(err, fs.ErrNotExist) {
+			code = CodeABINotFound
+		}
+		errs.addError(path, code, "%v", err)
+		return nil
+	}
+	return &contractAbi
+}
+
+// checkEventHandlers resolves each handler's event against contractAbi and
+// reports duplicate event/handler pairs. A nil contractAbi skips the event
+// checks; hasABIFile tells whether that is because no abiFile was configured.
+func checkEventHandlers(errs *ValidationErrors, path string, handlers []EventHandler, contractAbi *abi.ABI, hasABIFile bool) {
+	if len(handlers) > 0 && !hasABIFile {
+		errs.addWarning(path, CodeMissingABI, "no abiFile configured, event names cannot be verified")
+	}
+	type key struct{ event, handler string }
+	seen := make(map[key]int)
+	for i, h := range handlers {
+		hpath := fmt.Sprintf("%s[%d]", path, i)
+		k := key{h.Event, h.Handler}
+		if first, ok := seen[k]; ok {
+			errs.addError(hpath, CodeDuplicateHandler, "handler %s for event %s is already registered at %s[%d]", h.Handler, h.Event, path, first)
+		} else {
+			seen[k] = i
+		}
+		if contractAbi == nil || h.Event == "" {
+			continue
+		}
+		event, err := FindEvent(*contractAbi, h.Event)
+		switch {
+		case err == nil:
+			if _, err := CompileFilter(event, h.Filter); err != nil {
+				errs.addError(hpath+".filter", CodeInvalidFilter, "%v", err)
+			}
+		case errors.Is(err, ErrAmbiguousEvent):
+			errs.addError(hpath+".event", CodeAmbiguousEvent, "%v", err)
+		case errors.Is(err, ErrEventSignatureMismatch):
+			errs.addError(hpath+".event", CodeEventSignatureMismatch, "%v", err)
+		case errors.Is(err, ErrEventNotFound):
+			errs.addError(hpath+".event", CodeEventNotFound, "%v", err)
+		default:
+			errs.addError(hpath+".event", CodeInvalidValue, "%v", err)
+		}
+	}
+}
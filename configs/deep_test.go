@@ -0,0 +1,95 @@
+package configs
+
+import (
+	"errors"
+	"testing"
+)
+
+const testPluginsDir = "testdata/plugins_test"
+
+func newDeepTestConfig() *Config {
+	return &Config{
+		ProjectConfig: ProjectConfig{Org: "org", Name: "test", Kind: "ethereum", Network: "mainnet"},
+		PipelineConfig: PipelineConfig{
+			Name: "pipeline",
+			Source: Source{
+				Schema:     "ethereum",
+				SourceDB:   "db",
+				StartBlock: 1,
+				ABIFile:    "token.json",
+				Addresses:  []string{"0x0000000000000000000000000000000000000001"},
+			},
+			Metadata:    Metadata{Schema: "metadata", MetadataDB: "db"},
+			Destination: Destination{Schema: "dest", DestinationDB: "db"},
+			EventHandlers: []EventHandler{
+				{Event: "Transfer", Handler: "HandleTransfer"},
+				{Event: "Approval(indexed address,indexed address,uint256)", Handler: "HandleApproval"},
+			},
+		},
+	}
+}
+
+func TestCheckDeepValid(t *testing.T) {
+	c := newDeepTestConfig()
+	if errs := c.CheckDeep(testPluginsDir); len(errs) != 0 {
+		t.Fatalf("unexpected problems: %v", errs)
+	}
+}
+
+func TestCheckDeepProblems(t *testing.T) {
+	c := newDeepTestConfig()
+	p := &c.PipelineConfig
+	p.Source.Addresses = append(p.Source.Addresses, "0x1234")
+	p.EventHandlers = append(p.EventHandlers,
+		EventHandler{Event: "Approval", Handler: "HandleApproval"},
+		EventHandler{Event: "Transfer(address,address,uint256)", Handler: "HandleTransfer2"},
+		EventHandler{Event: "Transfer(address,uint256)", Handler: "HandleTransfer3"},
+		EventHandler{Event: "Mint", Handler: "HandleMint"},
+		EventHandler{Event: "Transfer", Handler: "HandleTransfer"},
+	)
+	p.Templates = []Template{
+		{Name: "pool", ABIFile: "missing.json"},
+		{Name: "pool", ABIFile: "broken.json"},
+	}
+
+	got := make(map[string]string)
+	for _, e := range c.CheckDeep(testPluginsDir).Errors() {
+		got[e.Path] = e.Code
+	}
+	want := map[string]string{
+		"pipeline.source.addresses[1]":    CodeInvalidAddress,
+		"pipeline.eventHandlers[2].event": CodeAmbiguousEvent,
+		"pipeline.eventHandlers[4].event": CodeEventSignatureMismatch,
+		"pipeline.eventHandlers[5].event": CodeEventNotFound,
+		"pipeline.eventHandlers[6]":       CodeDuplicateHandler,
+		"pipeline.templates[0].abiFile":   CodeABINotFound,
+		"pipeline.templates[1].name":      CodeDuplicateTemplate,
+		"pipeline.templates[1].abiFile":   CodeABIInvalid,
+	}
+	for path, code := range want {
+		if got[path] != code {
+			t.Errorf("%s: got code %q, want %q", path, got[path], code)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d problems, want %d: %v", len(got), len(want), got)
+	}
+}
+
+func TestFindEventIndexedMismatch(t *testing.T) {
+	contractAbi, err := LoadABIFile(testPluginsDir + "/abis/token.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = FindEvent(contractAbi, "Transfer(indexed address,address,indexed uint256)")
+	if !errors.Is(err, ErrEventSignatureMismatch) {
+		t.Errorf("got %v, want ErrEventSignatureMismatch", err)
+	}
+	event, err := FindEvent(contractAbi, "Approval(address indexed owner, address indexed spender, uint indexed id, uint value)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Inputs) != 4 {
+		t.Errorf("resolved wrong overload %s", event.Sig)
+	}
+}
@@ -0,0 +1,168 @@
+package configs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Entity declares a destination table. zsource creates it in
+// Destination.Schema and migrates it when the declaration changes.
+type Entity struct {
+	Name       string   `yaml:"name" json:"name"`
+	Columns    []Column `yaml:"columns" json:"columns"`
+	PrimaryKey []string `yaml:"primaryKey" json:"primaryKey"`
+	Indexes    []Index  `yaml:"indexes" json:"indexes,omitempty"`
+	// BlockColumn is the column holding the number of the block a row was
+	// written for. Rows of blocks orphaned by a chain reorganization are
+	// deleted through it, or through the provenance block column of
+	// entities with Provenance; other entities are left alone.
+	BlockColumn string `yaml:"blockColumn" json:"blockColumn,omitempty"`
+	// Provenance adds the provenance columns to the table. Rows written
+	// through Deps with a model embedding utils.Provenance record the block,
+	// pipeline and handler that last wrote them. Rolling back deletes rows by
+	// that last block, so it suits insert-only tables: see
+	// destination.DeleteBlocks.
+	Provenance bool `yaml:"provenance" json:"provenance,omitempty"`
+}
+
+// The provenance columns of entities with Provenance set.
+const (
+	ProvenanceBlockColumn    = "provenance_block"
+	ProvenanceHashColumn     = "provenance_hash"
+	ProvenancePipelineColumn = "provenance_pipeline"
+	ProvenanceHandlerColumn  = "provenance_handler"
+)
+
+var provenanceColumns = []Column{
+	{Name: ProvenanceBlockColumn, Type: "bigint", Nullable: true},
+	{Name: ProvenanceHashColumn, Type: "text", Nullable: true},
+	{Name: ProvenancePipelineColumn, Type: "text", Nullable: true},
+	{Name: ProvenanceHandlerColumn, Type: "text", Nullable: true},
+}
+
+// Expand returns the entity with the provenance columns, and an index on the
+// provenance block, added when Provenance is set.
+func (e Entity) Expand() Entity {
+	if !e.Provenance {
+		return e
+	}
+	e.Columns = append(append([]Column(nil), e.Columns...), provenanceColumns...)
+	e.Indexes = append(append([]Index(nil), e.Indexes...), Index{Columns: []string{ProvenanceBlockColumn, ProvenancePipelineColumn}})
+	return e
+}
+
+// RowBlockColumn returns the column holding the block each row was written
+// for: BlockColumn, else the provenance block column if Provenance is set,
+// else "".
+func (e *Entity) RowBlockColumn() string {
+	if e.BlockColumn == "" && e.Provenance {
+		return ProvenanceBlockColumn
+	}
+	return e.BlockColumn
+}
+
+// Column is a column of an Entity. Type is a Postgres type such as "text",
+// "numeric(78,0)" or "timestamp". Default is a SQL expression.
+type Column struct {
+	Name     string `yaml:"name" json:"name"`
+	Type     string `yaml:"type" json:"type"`
+	Nullable bool   `yaml:"nullable" json:"nullable,omitempty"`
+	Default  string `yaml:"default" json:"default,omitempty"`
+}
+
+// Index is a secondary index of an Entity. Name defaults to
+// <entity>_<columns>_idx.
+type Index struct {
+	Name    string   `yaml:"name" json:"name,omitempty"`
+	Columns []string `yaml:"columns" json:"columns"`
+	Unique  bool     `yaml:"unique" json:"unique,omitempty"`
+}
+
+// IndexName returns the configured or default name of the index.
+func (i Index) IndexName(entity string) string {
+	if i.Name != "" {
+		return i.Name
+	}
+	return fmt.Sprintf("%s_%s_idx", entity, strings.Join(i.Columns, "_"))
+}
+
+// Column returns the column with name, or nil.
+func (e *Entity) Column(name string) *Column {
+	for i := range e.Columns {
+		if e.Columns[i].Name == name {
+			return &e.Columns[i]
+		}
+	}
+	return nil
+}
+
+var (
+	identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	columnTypePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_ ]*(\([0-9, ]+\))?( ?\[\])*$`)
+)
+
+func checkEntities(errs *ValidationErrors, entities []Entity) {
+	names := make(map[string]bool)
+	for i, e := range entities {
+		path := fmt.Sprintf("pipeline.destination.entities[%d]", i)
+		switch {
+		case e.Name == "":
+			errs.addError(path+".name", CodeRequired, "entity name should not be empty")
+		case !identifierPattern.MatchString(e.Name):
+			errs.addError(path+".name", CodeInvalidValue, "entity name %q should be a lowercase identifier", e.Name)
+		case names[e.Name]:
+			errs.addError(path+".name", CodeInvalidValue, "entity %s is declared twice", e.Name)
+		}
+		names[e.Name] = true
+
+		if len(e.Columns) == 0 {
+			errs.addError(path+".columns", CodeRequired, "entity %s should have columns", e.Name)
+		}
+		columns := make(map[string]bool)
+		for j, c := range e.Columns {
+			cpath := fmt.Sprintf("%s.columns[%d]", path, j)
+			switch {
+			case !identifierPattern.MatchString(c.Name):
+				errs.addError(cpath+".name", CodeInvalidValue, "column name %q should be a lowercase identifier", c.Name)
+			case columns[c.Name]:
+				errs.addError(cpath+".name", CodeInvalidValue, "column %s is declared twice", c.Name)
+			}
+			columns[c.Name] = true
+			if e.Provenance && strings.HasPrefix(c.Name, "provenance_") {
+				errs.addError(cpath+".name", CodeInvalidValue, "column name %s is reserved for the provenance columns", c.Name)
+			}
+			if !columnTypePattern.MatchString(c.Type) {
+				errs.addError(cpath+".type", CodeInvalidValue, "column type %q is not a valid type", c.Type)
+			}
+		}
+
+		if len(e.PrimaryKey) == 0 {
+			errs.addError(path+".primaryKey", CodeRequired, "entity %s should have a primary key", e.Name)
+		}
+		for j, name := range e.PrimaryKey {
+			if c := e.Column(name); c == nil {
+				errs.addError(fmt.Sprintf("%s.primaryKey[%d]", path, j), CodeInvalidValue, "primary key column %s is not declared", name)
+			} else if c.Nullable {
+				errs.addError(fmt.Sprintf("%s.primaryKey[%d]", path, j), CodeInvalidValue, "primary key column %s should not be nullable", name)
+			}
+		}
+		if e.BlockColumn != "" && e.Column(e.BlockColumn) == nil {
+			errs.addError(path+".blockColumn", CodeInvalidValue, "block column %s is not declared", e.BlockColumn)
+		}
+		for j, index := range e.Indexes {
+			ipath := fmt.Sprintf("%s.indexes[%d]", path, j)
+			if len(index.Columns) == 0 {
+				errs.addError(ipath+".columns", CodeRequired, "index should have columns")
+			}
+			for _, name := range index.Columns {
+				if !columns[name] {
+					errs.addError(ipath+".columns", CodeInvalidValue, "index column %s is not declared", name)
+				}
+			}
+			if index.Name != "" && !identifierPattern.MatchString(index.Name) {
+				errs.addError(ipath+".name", CodeInvalidValue, "index name %q should be a lowercase identifier", index.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package configs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gopkg.in/yaml.v3"
+)
+
+const CodeInvalidFilter = "invalid_filter"
+
+// FilterValues lists the accepted values of an event argument. In YAML it is
+// either a single scalar or a sequence of scalars.
+type FilterValues []string
+
+func (v *FilterValues) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*v = FilterValues{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		values := make(FilterValues, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: filter value should be a scalar", item.Line)
+			}
+			// The raw text is kept so that hex values are not read as numbers.
+			values = append(values, item.Value)
+		}
+		*v = values
+		return nil
+	}
+	return fmt.Errorf("line %d: filter value should be a scalar or a list of scalars", node.Line)
+}
+
+// TopicFilter constrains log topics. Index i holds the accepted values of
+// topic i; a nil entry accepts any value.
+type TopicFilter [][]string
+
+// Where returns a SQL condition and its arguments matching the filter against
+// the topics column of a logs table. Postgres arrays are 1-based, so topic i
+// is topics[i+1]. An empty filter matches every log.
+func (f TopicFilter) Where() (string, []any) {
+	var conds []string
+	var args []any
+	for i, values := range f {
+		if values == nil {
+			continue
+		}
+		conds = append(conds, fmt.Sprintf("topics[%d] IN ?", i+1))
+		args = append(args, values)
+	}
+	if len(conds) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Match reports whether the topics of a log satisfy the filter. Topics are
+// compared case-insensitively.
+func (f TopicFilter) Match(topics []string) bool {
+	for i, values := range f {
+		if values == nil {
+			continue
+		}
+		if i >= len(topics) {
+			return false
+		}
+		matched := false
+		for _, value := range values {
+			if strings.EqualFold(value, topics[i]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// CompileFilter turns a handler's filter into topic constraints for event. The
+// event signature is always constrained unless the event is anonymous. Filter
+// keys must name indexed arguments; values are encoded the way the EVM
+// stores indexed arguments, dynamic types (string, bytes) being hashed.
+func CompileFilter(event *abi.Event, filter map[string]FilterValues) (TopicFilter, error) {
+	offset := 1
+	topics := TopicFilter{[]string{strings.ToLower(event.ID.Hex())}}
+	if event.Anonymous {
+		offset = 0
+		topics = TopicFilter{}
+	}
+
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	for len(topics) < offset+len(indexed) {
+		topics = append(topics, nil)
+	}
+
+	names := make([]string, 0, len(filter))
+	for name := range filter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pos := -1
+		for i, input := range indexed {
+			if input.Name == name {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			if hasArgument(event.Inputs, name) {
+				return nil, fmt.Errorf("argument %s of %s is not indexed, only indexed arguments can be filtered", name, event.Sig)
+			}
+			return nil, fmt.Errorf("event %s has no argument %s", event.Sig, name)
+		}
+		values := filter[name]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("filter on %s has no values", name)
+		}
+		encoded := make([]string, 0, len(values))
+		for _, value := range values {
+			topic, err := EncodeTopic(indexed[pos].Type, value)
+			if err != nil {
+				return nil, fmt.Errorf("filter on %s: %w", name, err)
+			}
+			encoded = append(encoded, topic)
+		}
+		topics[offset+pos] = encoded
+	}
+
+	// Trailing unconstrained topics add nothing to the query.
+	for len(topics) > 0 && topics[len(topics)-1] == nil {
+		topics = topics[:len(topics)-1]
+	}
+	return topics, nil
+}
+
+func hasArgument(args abi.Arguments, name string) bool {
+	for _, arg := range args {
+		if arg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeTopic encodes value, written as in a config file, as the topic of an
+// indexed argument of type t: a 0x-prefixed, lowercase, 32-byte hex string.
+func EncodeTopic(t abi.Type, value string) (string, error) {
+	var word [32]byte
+	switch t.T {
+	case abi.AddressTy:
+		if !common.IsHexAddress(value) {
+			return "", fmt.Errorf("%q is not an address", value)
+		}
+		copy(word[12:], common.HexToAddress(value).Bytes())
+	case abi.UintTy, abi.IntTy:
+		n, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return "", fmt.Errorf("%q is not an integer", value)
+		}
+		if t.T == abi.UintTy && n.Sign() < 0 {
+			return "", fmt.Errorf("%q is negative for %s", value, t.String())
+		}
+		if n.Sign() < 0 {
+			// Two's complement in 256 bits.
+			n.Add(n, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		if n.BitLen() > 256 {
+			return "", fmt.Errorf("%q overflows %s", value, t.String())
+		}
+		n.FillBytes(word[:])
+	case abi.BoolTy:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a bool", value)
+		}
+		if b {
+			word[31] = 1
+		}
+	case abi.FixedBytesTy:
+		b, err := decodeHex(value)
+		if err != nil || len(b) > t.Size {
+			return "", fmt.Errorf("%q is not a %s", value, t.String())
+		}
+		copy(word[:], b)
+	case abi.StringTy:
+		word = crypto.Keccak256Hash([]byte(value))
+	case abi.BytesTy:
+		b, err := decodeHex(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not hex bytes", value)
+		}
+		word = crypto.Keccak256Hash(b)
+	default:
+		return "", fmt.Errorf("filtering on %s arguments is not supported", t.String())
+	}
+	return "0x" + hex.EncodeToString(word[:]), nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+}
@@ -0,0 +1,80 @@
+package configs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCompileFilter(t *testing.T) {
+	contractAbi, err := LoadABIFile(testPluginsDir + "/abis/token.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := FindEvent(contractAbi, "Transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h EventHandler
+	err = yaml.Unmarshal([]byte(`
+event: Transfer
+handler: HandleTransfer
+filter:
+  to: 0x00000000000000000000000000000000000000AB
+`), &h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics, err := CompileFilter(event, h.Filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TopicFilter{
+		{"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"},
+		nil,
+		{"0x00000000000000000000000000000000000000000000000000000000000000ab"},
+	}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("got %v, want %v", topics, want)
+	}
+	where, args := topics.Where()
+	if where != "topics[1] IN ? AND topics[3] IN ?" || len(args) != 2 {
+		t.Errorf("Where() = %q, %v", where, args)
+	}
+	if !topics.Match([]string{want[0][0], "0x01", strings.ToUpper(want[2][0])}) {
+		t.Errorf("Match() = false for a matching log")
+	}
+	if topics.Match([]string{want[0][0], "0x01"}) || topics.Match([]string{want[0][0], "0x01", "0x02"}) {
+		t.Errorf("Match() = true for a non-matching log")
+	}
+
+	if _, err := CompileFilter(event, map[string]FilterValues{"value": {"1"}}); err == nil || !strings.Contains(err.Error(), "not indexed") {
+		t.Errorf("filter on non-indexed argument: got %v", err)
+	}
+}
+
+func TestEncodeTopic(t *testing.T) {
+	contractAbi, err := LoadABIFile(testPluginsDir + "/abis/token.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := FindEvent(contractAbi, "Approval(address,address,uint256,uint256)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics, err := CompileFilter(event, map[string]FilterValues{"id": {"1", "0x10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"0x0000000000000000000000000000000000000000000000000000000000000001",
+		"0x0000000000000000000000000000000000000000000000000000000000000010",
+	}
+	if len(topics) != 4 || !reflect.DeepEqual(topics[3], want) {
+		t.Errorf("got %v", topics)
+	}
+}
@@ -0,0 +1,96 @@
+package configs
+
+import (
+	"fmt"
+	"time"
+)
+
+// IntervalHandler runs a handler once per interval instead of on every block:
+// either every EveryBlocks blocks, or once per wall-clock interval of the
+// block timestamps (UTC). Exactly one of EveryBlocks and Every is set.
+//
+// Every is "hour", "day", "week" (starting on Monday), "month", or a Go
+// duration such as "15m" for intervals aligned on the Unix epoch. The handler
+// runs on the first block of each interval.
+type IntervalHandler struct {
+	Handler     string `yaml:"handler"`
+	EveryBlocks int64  `yaml:"everyBlocks"`
+	Every       string `yaml:"every"`
+	// Retry overrides the pipeline retry policy for this handler. A
+	// dead-lettered interval handler is not due again before its next
+	// interval; replaying it runs it on the interval of its block.
+	Retry *RetryPolicy `yaml:"retry,omitempty"`
+}
+
+// Bucket returns the index of the interval block falls into. Indexes grow with
+// time, so a handler is due when the bucket of a block is greater than the
+// last bucket it ran for.
+func (h IntervalHandler) Bucket(number int64, timestamp time.Time) (int64, error) {
+	if h.EveryBlocks > 0 {
+		return number / h.EveryBlocks, nil
+	}
+	t := timestamp.UTC()
+	switch h.Every {
+	case "hour":
+		return t.Unix() / 3600, nil
+	case "day":
+		return t.Unix() / 86400, nil
+	case "week":
+		// The Unix epoch is a Thursday, shift so that weeks start on Monday.
+		return (t.Unix() + 3*86400) / (7 * 86400), nil
+	case "month":
+		return int64(t.Year())*12 + int64(t.Month()) - 1, nil
+	}
+	d, err := time.ParseDuration(h.Every)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("interval %q should be hour, day, week, month or a duration of at least 1s", h.Every)
+	}
+	return t.Unix() / int64(d/time.Second), nil
+}
+
+// Spec returns the interval of the handler as "everyBlocks N" or "every D",
+// which tells apart the states of a handler configured with several
+// intervals.
+func (h IntervalHandler) Spec() string {
+	if h.EveryBlocks > 0 {
+		return fmt.Sprintf("everyBlocks %d", h.EveryBlocks)
+	}
+	return "every " + h.Every
+}
+
+// BucketStart returns the first block number or the start time of a bucket,
+// whichever applies to the handler.
+func (h IntervalHandler) BucketStart(bucket int64) (int64, time.Time) {
+	if h.EveryBlocks > 0 {
+		return bucket * h.EveryBlocks, time.Time{}
+	}
+	switch h.Every {
+	case "hour":
+		return 0, time.Unix(bucket*3600, 0).UTC()
+	case "day":
+		return 0, time.Unix(bucket*86400, 0).UTC()
+	case "week":
+		return 0, time.Unix(bucket*7*86400-3*86400, 0).UTC()
+	case "month":
+		return 0, time.Date(int(bucket/12), time.Month(bucket%12+1), 1, 0, 0, 0, 0, time.UTC)
+	}
+	d, _ := time.ParseDuration(h.Every)
+	return 0, time.Unix(bucket*int64(d/time.Second), 0).UTC()
+}
+
+func checkIntervalHandler(errs *ValidationErrors, path string, h IntervalHandler) {
+	errs.required(path+".handler", h.Handler, "interval handler name should not be empty")
+	switch {
+	case h.EveryBlocks != 0 && h.Every != "":
+		errs.addError(path, CodeConflictingFields, "interval handler should set either everyBlocks or every, not both")
+	case h.EveryBlocks < 0:
+		errs.addError(path+".everyBlocks", CodeInvalidValue, "everyBlocks should be positive")
+	case h.EveryBlocks == 0 && h.Every == "":
+		errs.addError(path, CodeRequired, "interval handler should set everyBlocks or every")
+	case h.Every != "":
+		if _, err := h.Bucket(0, time.Time{}); err != nil {
+			errs.addError(path+".every", CodeInvalidValue, "%v", err)
+		}
+	}
+	checkRetryPolicy(errs, path+".retry", h.Retry)
+}
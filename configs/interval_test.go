@@ -0,0 +1,59 @@
+package configs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntervalHandlerBucket(t *testing.T) {
+	ts := time.Date(2024, time.April, 17, 10, 30, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		handler IntervalHandler
+		start   time.Time
+	}{
+		{IntervalHandler{Every: "hour"}, time.Date(2024, time.April, 17, 10, 0, 0, 0, time.UTC)},
+		{IntervalHandler{Every: "day"}, time.Date(2024, time.April, 17, 0, 0, 0, 0, time.UTC)},
+		{IntervalHandler{Every: "week"}, time.Date(2024, time.April, 15, 0, 0, 0, 0, time.UTC)},
+		{IntervalHandler{Every: "month"}, time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{IntervalHandler{Every: "15m"}, time.Date(2024, time.April, 17, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		bucket, err := tt.handler.Bucket(0, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, start := tt.handler.BucketStart(bucket); !start.Equal(tt.start) {
+			t.Errorf("%s: interval starts at %v, want %v", tt.handler.Every, start, tt.start)
+		}
+		next, _ := tt.handler.Bucket(0, ts.Add(-time.Nanosecond).Add(tt.start.Sub(ts)))
+		if next != bucket-1 {
+			t.Errorf("%s: instant before the interval start is in bucket %d, want %d", tt.handler.Every, next, bucket-1)
+		}
+	}
+
+	h := IntervalHandler{EveryBlocks: 100}
+	if bucket, _ := h.Bucket(1234, ts); bucket != 12 {
+		t.Errorf("block bucket = %d, want 12", bucket)
+	}
+	if spec := h.Spec(); spec != "everyBlocks 100" {
+		t.Errorf("Spec() = %q, want everyBlocks 100", spec)
+	}
+	if spec := (IntervalHandler{Every: "15m"}).Spec(); spec != "every 15m" {
+		t.Errorf("Spec() = %q, want every 15m", spec)
+	}
+}
+
+func TestCheckIntervalHandler(t *testing.T) {
+	for _, h := range []IntervalHandler{
+		{Handler: "h"},
+		{Handler: "h", EveryBlocks: 10, Every: "day"},
+		{Handler: "h", Every: "fortnight"},
+		{Handler: "h", EveryBlocks: -1},
+	} {
+		var errs ValidationErrors
+		checkIntervalHandler(&errs, "pipeline.intervalHandlers[0]", h)
+		if len(errs) != 1 {
+			t.Errorf("%+v: got %v, want one problem", h, errs)
+		}
+	}
+}
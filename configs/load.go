@@ -0,0 +1,347 @@
+package configs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultProjectFile  = "project.yml"
+	defaultPipelineFile = "pipeline.yml"
+)
+
+// Load reads a project.yml and pipeline.yml pair into a Config, expanding
+// ${VAR} and ${VAR:-default} references in its values from the environment,
+// and validates the result. If the project sets an environment and a
+// pipeline.<environment>.yml overlay exists next to the pipeline file, it is
+// merged over the pipeline, see LoadEnvironment.
+func Load(projectPath string, pipelinePath string) (*Config, error) {
+	return LoadEnvironment(projectPath, pipelinePath, "")
+}
+
+// LoadReader is like Load but reads the project and pipeline definitions from
+// readers. Errors are reported against the names project.yml and pipeline.yml.
+// Overlays are merged over the pipeline in order and reported as
+// pipeline.overlay<N>.yml.
+func LoadReader(project io.Reader, pipeline io.Reader, overlays ...io.Reader) (*Config, error) {
+	projectData, err := io.ReadAll(project)
+	if err != nil {
+		return nil, err
+	}
+	pipelineData, err := io.ReadAll(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var overlayFiles []configFile
+	for i, overlay := range overlays {
+		data, err := io.ReadAll(overlay)
+		if err != nil {
+			return nil, err
+		}
+		overlayFiles = append(overlayFiles, configFile{name: fmt.Sprintf("pipeline.overlay%d.yml", i), data: data})
+	}
+	return load(
+		configFile{name: defaultProjectFile, data: projectData},
+		configFile{name: defaultPipelineFile, data: pipelineData},
+		overlayFiles...)
+}
+
+// configFile is the raw content of a configuration file and the name errors
+// are reported against.
+type configFile struct {
+	name string
+	data []byte
+}
+
+func readConfigFile(path string) (configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return configFile{}, err
+	}
+	return configFile{name: path, data: data}, nil
+}
+
+func load(project configFile, pipeline configFile, overlays ...configFile) (*Config, error) {
+	c := &Config{sources: &sources{}}
+	var err error
+	c.sources.project, err = parseFile(project)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sources.project.decode(&c.ProjectConfig); err != nil {
+		return nil, err
+	}
+	c.sources.pipeline, err = parseFile(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	for _, overlay := range overlays {
+		o, err := parseFile(overlay)
+		if err != nil {
+			return nil, err
+		}
+		c.sources.pipeline.merge(o)
+	}
+	if err := c.sources.pipeline.decode(&c.PipelineConfig); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseFile parses f and expands the environment references in its values.
+// The parsed document is kept for decoding, merging and later position
+// lookups.
+func parseFile(f configFile) (*source, error) {
+	if len(bytes.TrimSpace(f.data)) == 0 {
+		return nil, &PositionError{File: f.name, Err: errors.New("file is empty")}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(f.data, &doc); err != nil {
+		return nil, wrapYAMLError(f.name, err)
+	}
+	if err := expandEnv(f.name, &doc); err != nil {
+		return nil, err
+	}
+	return &source{file: f.name, root: &doc}, nil
+}
+
+// decode unmarshals the document into out. Type errors are reported against
+// the file of the document's root, overlays included.
+func (s *source) decode(out any) error {
+	if err := s.root.Decode(out); err != nil {
+		return wrapYAMLError(s.file, err)
+	}
+	return nil
+}
+
+// PositionError is an error tied to a location in a configuration file. Line
+// is 1-based; zero means the position is unknown.
+type PositionError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *PositionError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// wrapYAMLError rewrites yaml.v3 errors, which embed "line N: " in their
+// message, into PositionErrors. A TypeError may carry several problems; only
+// the first is positioned, the rest are kept in the message.
+func wrapYAMLError(file string, err error) error {
+	msg := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = strings.Join(typeErr.Errors, "; ")
+	}
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	var line int
+	if n, _ := fmt.Sscanf(msg, "line %d: ", &line); n == 1 {
+		msg = strings.TrimPrefix(msg, fmt.Sprintf("line %d: ", line))
+	}
+	return &PositionError{File: file, Line: line, Err: errors.New(msg)}
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in the scalars under node,
+// keys included, with values from the environment. A reference to an unset
+// variable without a default is an error. "$${" escapes a literal "${".
+// Expansion is done on parsed values, so a value such as a password holding
+// "#", ": " or a newline stays a single string instead of changing the
+// structure of the document. Comments are left alone.
+func expandEnv(file string, node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		expanded, err := expandLine(node.Value)
+		if err != nil {
+			return &PositionError{File: file, Line: node.Line, Err: err}
+		}
+		if expanded != node.Value {
+			node.Value = expanded
+			if node.Style&yaml.TaggedStyle == 0 {
+				// Resolve the tag again, so that ${START_BLOCK} decodes
+				// into an int like a literal number would.
+				node.Tag = ""
+			}
+		}
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := expandEnv(file, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandLine(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference %q", s[i:])
+		}
+		b.WriteString(s[:i])
+		value, err := lookupRef(s[i+2 : i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// lookupRef resolves the inside of a ${...} reference.
+func lookupRef(ref string) (string, error) {
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if !validEnvName(name) {
+		return "", fmt.Errorf("invalid environment variable name %q", name)
+	}
+	if value, ok := os.LookupEnv(name); ok && (value != "" || !hasDefault) {
+		return value, nil
+	}
+	if hasDefault {
+		return def, nil
+	}
+	return "", fmt.Errorf("environment variable %s is not set", name)
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// source is a parsed configuration file, possibly with overlays merged in.
+type source struct {
+	file string
+	root *yaml.Node
+	// origin maps the roots of subtrees merged in from overlays to the file
+	// they came from. Nodes not reachable through it belong to file.
+	origin map[*yaml.Node]string
+}
+
+// sources holds the project and pipeline documents a Config was loaded from.
+type sources struct {
+	project  *source
+	pipeline *source
+}
+
+// annotate sets File and Line on each problem from the document its path
+// points into. Problems about missing fields point at the closest parent that
+// exists.
+func (s *sources) annotate(errs ValidationErrors) {
+	if s == nil {
+		return
+	}
+	for _, e := range errs {
+		head, rest, _ := strings.Cut(e.Path, ".")
+		var src *source
+		switch head {
+		case "project":
+			src = s.project
+		case "pipeline":
+			src = s.pipeline
+		}
+		if src == nil {
+			continue
+		}
+		e.File, e.Line = src.position(rest)
+	}
+}
+
+// position returns the file and line of the node at path, a dotted path with
+// optional [i] indexes such as "source.addresses[1]".
+func (s *source) position(path string) (string, int) {
+	node := documentRoot(s.root)
+	file, line := s.file, node.Line
+	if path == "" {
+		return file, line
+	}
+	visit := func(n *yaml.Node) {
+		if f, ok := s.origin[n]; ok {
+			file = f
+		}
+		line = n.Line
+	}
+	for _, seg := range strings.Split(path, ".") {
+		key, indexes := splitIndexes(seg)
+		keyNode, value := mappingValue(node, key)
+		if value == nil {
+			return file, line
+		}
+		visit(keyNode)
+		node = value
+		for _, i := range indexes {
+			if node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+				return file, line
+			}
+			node = node.Content[i]
+			visit(node)
+		}
+	}
+	return file, line
+}
+
+// splitIndexes splits "eventHandlers[2][0]" into "eventHandlers" and [2 0].
+func splitIndexes(seg string) (string, []int) {
+	key, rest, found := strings.Cut(seg, "[")
+	if !found {
+		return seg, nil
+	}
+	var indexes []int
+	for _, part := range strings.Split(strings.TrimSuffix(rest, "]"), "][") {
+		var i int
+		if _, err := fmt.Sscanf(part, "%d", &i); err != nil {
+			break
+		}
+		indexes = append(indexes, i)
+	}
+	return key, indexes
+}
+
+// mappingValue returns the key and value nodes for key in a mapping node.
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	if i := mappingIndex(node, key); i >= 0 {
+		return node.Content[i], node.Content[i+1]
+	}
+	return nil, nil
+}
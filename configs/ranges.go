@@ -0,0 +1,127 @@
+package configs
+
+import "fmt"
+
+// BlockRange is an inclusive range of blocks. A nil End leaves the range
+// open, which is only allowed for the last range of a pipeline; an End of 0
+// ends it at the genesis block.
+type BlockRange struct {
+	Start int64  `yaml:"start"`
+	End   *int64 `yaml:"end,omitempty"`
+}
+
+// Open reports whether the range has no end block.
+func (r BlockRange) Open() bool {
+	return r.End == nil
+}
+
+// Contains reports whether number falls in the range.
+func (r BlockRange) Contains(number int64) bool {
+	return number >= r.Start && (r.Open() || number <= *r.End)
+}
+
+func (r BlockRange) String() string {
+	if r.Open() {
+		return fmt.Sprintf("[%d, ...]", r.Start)
+	}
+	return fmt.Sprintf("[%d, %d]", r.Start, *r.End)
+}
+
+const CodeConflictingFields = "conflicting_fields"
+
+// checkBlockRanges validates startBlock, endBlock and ranges. startBlock may
+// only be 0 when an endBlock bounds the range, since 0 is otherwise
+// indistinguishable from an unset field.
+func (c *Config) checkBlockRanges(errs *ValidationErrors) {
+	s := &c.PipelineConfig.Source
+	if len(s.Ranges) > 0 {
+		if s.StartBlock != 0 || s.EndBlock != 0 {
+			errs.addError("pipeline.source.ranges", CodeConflictingFields, "source ranges cannot be combined with startBlock or endBlock")
+		}
+		for i, r := range s.Ranges {
+			path := fmt.Sprintf("pipeline.source.ranges[%d]", i)
+			switch {
+			case r.Start < 0:
+				errs.addError(path+".start", CodeInvalidValue, "range start should not be negative")
+			case !r.Open() && *r.End < 0:
+				errs.addError(path+".end", CodeInvalidValue, "range end should not be negative")
+			case r.Open() && i != len(s.Ranges)-1:
+				errs.addError(path+".end", CodeRequired, "only the last range may omit its end block")
+			case !r.Open() && *r.End < r.Start:
+				errs.addError(path+".end", CodeInvalidValue, "range end %d is before its start %d", *r.End, r.Start)
+			case i > 0 && !s.Ranges[i-1].Open() && r.Start <= *s.Ranges[i-1].End:
+				errs.addError(path+".start", CodeInvalidValue, "range %v overlaps or is not after range %v", r, s.Ranges[i-1])
+			}
+		}
+		return
+	}
+
+	switch {
+	case s.StartBlock < 0:
+		errs.addError("pipeline.source.startBlock", CodeInvalidValue, "source startBlock should not be negative")
+	case s.StartBlock == 0 && s.EndBlock == 0:
+		errs.addError("pipeline.source.startBlock", CodeRequired, "source startBlock should not be 0 or empty")
+	}
+	switch {
+	case s.EndBlock < 0:
+		errs.addError("pipeline.source.endBlock", CodeInvalidValue, "source endBlock should not be negative")
+	case s.EndBlock != 0 && s.EndBlock < s.StartBlock:
+		errs.addError("pipeline.source.endBlock", CodeInvalidValue, "source endBlock %d is before startBlock %d", s.EndBlock, s.StartBlock)
+	}
+}
+
+// BlockRanges returns the ranges the pipeline indexes, in ascending order:
+// either the configured ranges or a single range built from startBlock and
+// endBlock.
+func (c *Config) BlockRanges() []BlockRange {
+	s := c.PipelineConfig.Source
+	if len(s.Ranges) > 0 {
+		return s.Ranges
+	}
+	r := BlockRange{Start: int64(s.StartBlock)}
+	if s.EndBlock != 0 {
+		end := int64(s.EndBlock)
+		r.End = &end
+	}
+	return []BlockRange{r}
+}
+
+// InScope reports whether block number is indexed by the pipeline.
+func (c *Config) InScope(number int64) bool {
+	for _, r := range c.BlockRanges() {
+		if r.Contains(number) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextInScope returns the first block at or after number that is in scope, and
+// false if there is none, i.e. number is past the last bounded range.
+func (c *Config) NextInScope(number int64) (int64, bool) {
+	for _, r := range c.BlockRanges() {
+		if r.Contains(number) {
+			return number, true
+		}
+		if number < r.Start {
+			return r.Start, true
+		}
+	}
+	return 0, false
+}
+
+// GetStartBlock returns the first block in scope.
+func (c *Config) GetStartBlock() int64 {
+	return c.BlockRanges()[0].Start
+}
+
+// GetEndBlock returns the last block in scope, and false if the pipeline
+// follows the chain without an end.
+func (c *Config) GetEndBlock() (int64, bool) {
+	ranges := c.BlockRanges()
+	last := ranges[len(ranges)-1]
+	if last.Open() {
+		return 0, false
+	}
+	return *last.End, true
+}
@@ -0,0 +1,95 @@
+package configs
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func endAt(block int64) *int64 {
+	return &block
+}
+
+func TestBlockRangesScope(t *testing.T) {
+	c := &Config{PipelineConfig: PipelineConfig{Source: Source{
+		Ranges: []BlockRange{{Start: 0, End: endAt(10)}, {Start: 20, End: endAt(30)}, {Start: 50}},
+	}}}
+	var errs ValidationErrors
+	c.checkBlockRanges(&errs)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected problems: %v", errs)
+	}
+
+	for number, want := range map[int64]bool{0: true, 10: true, 11: false, 25: true, 31: false, 50: true, 1000: true} {
+		if got := c.InScope(number); got != want {
+			t.Errorf("InScope(%d) = %v, want %v", number, got, want)
+		}
+	}
+	if next, ok := c.NextInScope(12); !ok || next != 20 {
+		t.Errorf("NextInScope(12) = %d, %v", next, ok)
+	}
+	if _, ok := c.GetEndBlock(); ok {
+		t.Errorf("open last range should have no end block")
+	}
+}
+
+func TestBlockRangesBounded(t *testing.T) {
+	c := &Config{PipelineConfig: PipelineConfig{Source: Source{StartBlock: 0, EndBlock: 100}}}
+	var errs ValidationErrors
+	c.checkBlockRanges(&errs)
+	if len(errs) != 0 {
+		t.Fatalf("startBlock 0 with endBlock should be valid: %v", errs)
+	}
+	if _, ok := c.NextInScope(101); ok {
+		t.Errorf("NextInScope past endBlock should report no block")
+	}
+	if end, ok := c.GetEndBlock(); !ok || end != 100 {
+		t.Errorf("GetEndBlock() = %d, %v", end, ok)
+	}
+}
+
+func TestBlockRangesGenesis(t *testing.T) {
+	var r BlockRange
+	if err := yaml.Unmarshal([]byte("start: 0\nend: 0\n"), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Open() {
+		t.Fatalf("end: 0 decoded as an open range %v", r)
+	}
+	c := &Config{PipelineConfig: PipelineConfig{Source: Source{Ranges: []BlockRange{r}}}}
+	var errs ValidationErrors
+	c.checkBlockRanges(&errs)
+	if len(errs) != 0 {
+		t.Fatalf("the genesis block range should be valid: %v", errs)
+	}
+	if !c.InScope(0) || c.InScope(1) {
+		t.Errorf("InScope(0), InScope(1) = %v, %v, want only block 0", c.InScope(0), c.InScope(1))
+	}
+	if _, ok := c.NextInScope(1); ok {
+		t.Errorf("NextInScope past the genesis block should report no block")
+	}
+	if end, ok := c.GetEndBlock(); !ok || end != 0 {
+		t.Errorf("GetEndBlock() = %d, %v, want 0, true", end, ok)
+	}
+}
+
+func TestBlockRangesInvalid(t *testing.T) {
+	tests := []struct {
+		path   string
+		source Source
+	}{
+		{"pipeline.source.ranges[0].end", Source{Ranges: []BlockRange{{Start: 0}, {Start: 10, End: endAt(20)}}}},
+		{"pipeline.source.ranges[1].start", Source{Ranges: []BlockRange{{Start: 0, End: endAt(10)}, {Start: 10, End: endAt(20)}}}},
+		{"pipeline.source.ranges[0].end", Source{Ranges: []BlockRange{{Start: 10, End: endAt(5)}}}},
+		{"pipeline.source.ranges", Source{StartBlock: 1, Ranges: []BlockRange{{Start: 1}}}},
+		{"pipeline.source.endBlock", Source{StartBlock: 10, EndBlock: 5}},
+	}
+	for _, tt := range tests {
+		c := &Config{PipelineConfig: PipelineConfig{Source: tt.source}}
+		var errs ValidationErrors
+		c.checkBlockRanges(&errs)
+		if len(errs) != 1 || errs[0].Path != tt.path {
+			t.Errorf("%+v: got %v, want one problem at %s", tt.source, errs, tt.path)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package configs
+
+import "time"
+
+// Defaults of RetryPolicy fields left unset.
+const (
+	DefaultMaxAttempts    = 3
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+)
+
+// RetryPolicy decides how a block is retried when one of its handlers fails
+// with a transient error. The pipeline policy applies to every handler, and a
+// handler policy overrides the fields it sets. Backoff doubles after each
+// attempt, from InitialBackoff up to MaxBackoff.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a block is tried, including the
+	// first one.
+	MaxAttempts    int           `yaml:"maxAttempts,omitempty"`
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff,omitempty"`
+	// DeadLetter records the block in the dead_letters metadata table once
+	// attempts are exhausted, or right away for permanent errors, and
+	// processes the block without the failing handler. Without it the
+	// pipeline stops.
+	DeadLetter *bool `yaml:"deadLetter,omitempty"`
+}
+
+// Override returns p with the fields set in o replaced.
+func (p RetryPolicy) Override(o *RetryPolicy) RetryPolicy {
+	if o == nil {
+		return p
+	}
+	if o.MaxAttempts != 0 {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.InitialBackoff != 0 {
+		p.InitialBackoff = o.InitialBackoff
+	}
+	if o.MaxBackoff != 0 {
+		p.MaxBackoff = o.MaxBackoff
+	}
+	if o.DeadLetter != nil {
+		p.DeadLetter = o.DeadLetter
+	}
+	return p
+}
+
+// Attempts returns MaxAttempts or its default.
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts == 0 {
+		return DefaultMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns how long to wait after the given failed attempt, counted
+// from 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff, limit := p.InitialBackoff, p.MaxBackoff
+	if backoff == 0 {
+		backoff = DefaultInitialBackoff
+	}
+	if limit == 0 {
+		limit = DefaultMaxBackoff
+	}
+	for i := 1; i < attempt && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
+
+// DeadLetters reports whether exhausted blocks are dead-lettered.
+func (p RetryPolicy) DeadLetters() bool {
+	return p.DeadLetter != nil && *p.DeadLetter
+}
+
+// RetryPolicy returns the retry policy of the handler called name: the
+// pipeline policy overridden by the policy of the first event, block, call or
+// interval handler with that name.
+func (c *Config) RetryPolicy(name string) RetryPolicy {
+	p := c.PipelineConfig
+	for _, h := range p.EventHandlers {
+		if h.Handler == name {
+			return p.Retry.Override(h.Retry)
+		}
+	}
+	for _, t := range p.Templates {
+		for _, h := range t.EventHandlers {
+			if h.Handler == name {
+				return p.Retry.Override(h.Retry)
+			}
+		}
+	}
+	for _, h := range p.BlockHandlers {
+		if h.Handler == name {
+			return p.Retry.Override(h.Retry)
+		}
+	}
+	for _, h := range p.CallHandlers {
+		if h.Handler == name {
+			return p.Retry.Override(h.Retry)
+		}
+	}
+	for _, h := range p.IntervalHandlers {
+		if h.Handler == name {
+			return p.Retry.Override(h.Retry)
+		}
+	}
+	return p.Retry
+}
+
+func checkRetryPolicy(errs *ValidationErrors, path string, p *RetryPolicy) {
+	if p == nil {
+		return
+	}
+	if p.MaxAttempts < 0 {
+		errs.addError(path+".maxAttempts", CodeInvalidValue, "maxAttempts should not be negative")
+	}
+	if p.InitialBackoff < 0 {
+		errs.addError(path+".initialBackoff", CodeInvalidValue, "initialBackoff should not be negative")
+	}
+	if p.MaxBackoff < 0 {
+		errs.addError(path+".maxBackoff", CodeInvalidValue, "maxBackoff should not be negative")
+	}
+	if p.InitialBackoff > 0 && p.MaxBackoff > 0 && p.MaxBackoff < p.InitialBackoff {
+		errs.addError(path+".maxBackoff", CodeInvalidValue, "maxBackoff %s is shorter than initialBackoff %s", p.MaxBackoff, p.InitialBackoff)
+	}
+}
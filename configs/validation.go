@@ -0,0 +1,111 @@
+package configs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity tells whether a validation problem blocks the config from being
+// used (error) or is only reported (warning).
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Stable codes for validation problems, meant to be matched by tooling.
+const (
+	CodeRequired     = "required"
+	CodeInvalidValue = "invalid_value"
+	CodeNoHandlers   = "no_handlers"
+)
+
+// ValidationError is a single problem found in a Config. Path is the YAML
+// path of the offending field, prefixed with "project" or "pipeline", e.g.
+// "pipeline.source.startBlock". File and Line are set when the config was
+// read by Load.
+type ValidationError struct {
+	Path     string   `json:"path"`
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d", e.Line)
+		}
+		b.WriteString(": ")
+	}
+	if e.Severity == SeverityWarning {
+		b.WriteString("warning: ")
+	}
+	fmt.Fprintf(&b, "%s: %s", e.Path, e.Message)
+	return b.String()
+}
+
+// ValidationErrors collects every problem found while validating a Config.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Errors returns only the problems with error severity.
+func (e ValidationErrors) Errors() ValidationErrors {
+	return e.filter(SeverityError)
+}
+
+// Warnings returns only the problems with warning severity.
+func (e ValidationErrors) Warnings() ValidationErrors {
+	return e.filter(SeverityWarning)
+}
+
+// HasErrors reports whether any problem has error severity.
+func (e ValidationErrors) HasErrors() bool {
+	return len(e.Errors()) > 0
+}
+
+// Err returns e as an error if it contains at least one error-severity
+// problem, and nil otherwise, so warnings alone never fail validation.
+func (e ValidationErrors) Err() error {
+	if !e.HasErrors() {
+		return nil
+	}
+	return e
+}
+
+func (e ValidationErrors) filter(severity Severity) ValidationErrors {
+	var out ValidationErrors
+	for _, err := range e {
+		if err.Severity == severity {
+			out = append(out, err)
+		}
+	}
+	return out
+}
+
+func (e *ValidationErrors) addError(path string, code string, format string, args ...any) {
+	*e = append(*e, &ValidationError{Path: path, Code: code, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationErrors) addWarning(path string, code string, format string, args ...any) {
+	*e = append(*e, &ValidationError{Path: path, Code: code, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// required records a CodeRequired error when value is empty.
+func (e *ValidationErrors) required(path string, value string, msg string) {
+	if value == "" {
+		e.addError(path, CodeRequired, msg)
+	}
+}
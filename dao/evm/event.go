@@ -1,3 +1,257 @@
 package evm
 
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Event holds the decoded arguments of an event or a call, keyed by argument
+// name. Values are native Go values: *big.Int, common.Address, []byte,
+// [N]byte, string, bool, fixed size integers, Event for tuples and []Event
+// for arrays of tuples.
 type Event map[string]any
+
+var (
+	ErrMissingArgument = errors.New("missing argument")
+	ErrArgumentType    = errors.New("argument of the wrong type")
+)
+
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+// Address returns argument name as an address.
+func (e Event) Address(name string) (common.Address, error) {
+	var v common.Address
+	err := e.get(name, &v)
+	return v, err
+}
+
+// BigInt returns argument name, an integer of any size, as a *big.Int.
+func (e Event) BigInt(name string) (*big.Int, error) {
+	var v *big.Int
+	err := e.get(name, &v)
+	return v, err
+}
+
+// Uint64 returns argument name, an integer that fits, as a uint64.
+func (e Event) Uint64(name string) (uint64, error) {
+	var v uint64
+	err := e.get(name, &v)
+	return v, err
+}
+
+// Bytes32 returns argument name, a bytes32 or the hash of an indexed dynamic
+// argument, as a common.Hash.
+func (e Event) Bytes32(name string) (common.Hash, error) {
+	var v common.Hash
+	err := e.get(name, &v)
+	return v, err
+}
+
+// Bool returns argument name as a bool.
+func (e Event) Bool(name string) (bool, error) {
+	var v bool
+	err := e.get(name, &v)
+	return v, err
+}
+
+// String returns argument name as a string.
+func (e Event) String(name string) (string, error) {
+	var v string
+	err := e.get(name, &v)
+	return v, err
+}
+
+func (e Event) get(name string, out any) error {
+	v, ok := e[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingArgument, name)
+	}
+	return assign(name, reflect.ValueOf(out).Elem(), v)
+}
+
+// Unmarshal binds the arguments of the event to the fields of the struct v
+// points to. A field binds the argument named by its `evm` tag, or else the
+// argument whose name matches the field name case-insensitively. Fields
+// tagged "-" are skipped, and fields tagged with the ",optional" option are
+// left alone when their argument is missing; any other missing argument is
+// an ErrMissingArgument error.
+//
+// Values are converted to the field type when no information is lost:
+// integers to any integer type they fit in or to *big.Int, byte arrays to
+// byte arrays of the same length such as common.Hash, tuples (Event) to
+// structs and arrays of tuples to slices of structs, bound the same way.
+func (e Event) Unmarshal(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("evm: Unmarshal needs a pointer to a struct, got %T", v)
+	}
+	return e.bind("", rv.Elem())
+}
+
+// bind binds the arguments of e to the fields of the struct dst. path is the
+// name of the tuple e is, for errors.
+func (e Event) bind(path string, dst reflect.Value) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, optional := field.Name, false
+		tag, tagged := field.Tag.Lookup("evm")
+		if tag == "-" {
+			continue
+		}
+		if tagged {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				optional = optional || option == "optional"
+			}
+		}
+		value, key, ok := e.lookup(name, tagged && !strings.HasPrefix(tag, ","))
+		if !ok {
+			if optional {
+				continue
+			}
+			return fmt.Errorf("%w: %s", ErrMissingArgument, path+name)
+		}
+		if err := assign(path+key, dst.Field(i), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the argument called name, matched case-insensitively unless
+// exact is set, and its actual name. An exact match wins; among several
+// case-insensitive ones, the first name in sort order does.
+func (e Event) lookup(name string, exact bool) (any, string, bool) {
+	if v, ok := e[name]; ok {
+		return v, name, true
+	}
+	if exact {
+		return nil, "", false
+	}
+	var keys []string
+	for key := range e {
+		if strings.EqualFold(key, name) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, "", false
+	}
+	sort.Strings(keys)
+	return e[keys[0]], keys[0], true
+}
+
+// assign sets dst to argument value v, converting it as described on
+// Unmarshal. name is the argument name, for errors.
+func assign(name string, dst reflect.Value, v any) error {
+	if v == nil {
+		return fmt.Errorf("%w: %s is nil", ErrArgumentType, name)
+	}
+	src := reflect.ValueOf(v)
+
+	switch value := v.(type) {
+	case Event:
+		if dst.Kind() == reflect.Struct {
+			return value.bind(name+".", dst)
+		}
+	case []Event:
+		if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Struct {
+			out := reflect.MakeSlice(dst.Type(), len(value), len(value))
+			for i, tuple := range value {
+				if err := tuple.bind(fmt.Sprintf("%s[%d].", name, i), out.Index(i)); err != nil {
+					return err
+				}
+			}
+			dst.Set(out)
+			return nil
+		}
+	}
+
+	if src.Type().AssignableTo(dst.Type()) {
+		if n, ok := v.(*big.Int); ok && n != nil {
+			// Copy, as setInteger does, so that dst does not share the
+			// argument.
+			src = reflect.ValueOf(new(big.Int).Set(n))
+		}
+		dst.Set(src)
+		return nil
+	}
+	if n, ok := toBig(src); ok {
+		if setInteger(dst, n) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s is %v, which does not fit in %s", ErrArgumentType, name, n, dst.Type())
+	}
+	if src.Kind() == reflect.Array && dst.Kind() == reflect.Array && src.Len() == dst.Len() && src.Type().ConvertibleTo(dst.Type()) {
+		dst.Set(src.Convert(dst.Type()))
+		return nil
+	}
+	if src.Kind() == reflect.Slice && src.Type().Elem().Kind() == reflect.Uint8 && dst.Kind() == reflect.Array && dst.Type().Elem().Kind() == reflect.Uint8 {
+		if src.Len() != dst.Len() {
+			return fmt.Errorf("%w: %s has %d bytes, want %d", ErrArgumentType, name, src.Len(), dst.Len())
+		}
+		reflect.Copy(dst, src)
+		return nil
+	}
+	if (src.Kind() == reflect.Slice || src.Kind() == reflect.Array) && dst.Kind() == reflect.Slice {
+		out := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := assign(fmt.Sprintf("%s[%d]", name, i), out.Index(i), src.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+		return nil
+	}
+	return fmt.Errorf("%w: %s is %T, want %s", ErrArgumentType, name, v, dst.Type())
+}
+
+// toBig returns integer values as a big.Int.
+func toBig(v reflect.Value) (*big.Int, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint()), true
+	}
+	if v.Type() == bigIntType && !v.IsNil() {
+		return v.Interface().(*big.Int), true
+	}
+	return nil, false
+}
+
+// setInteger sets dst, an integer or a *big.Int, to n if it fits.
+func setInteger(dst reflect.Value, n *big.Int) bool {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !n.IsInt64() || dst.OverflowInt(n.Int64()) {
+			return false
+		}
+		dst.SetInt(n.Int64())
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !n.IsUint64() || dst.OverflowUint(n.Uint64()) {
+			return false
+		}
+		dst.SetUint(n.Uint64())
+		return true
+	}
+	if dst.Type() == bigIntType {
+		dst.Set(reflect.ValueOf(new(big.Int).Set(n)))
+		return true
+	}
+	return false
+}
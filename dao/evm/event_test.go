@@ -0,0 +1,135 @@
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var owner = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+
+func testEvent() Event {
+	return Event{
+		"from":    owner,
+		"value":   big.NewInt(1000),
+		"nonce":   uint8(7),
+		"topic":   common.HexToHash("0x01"),
+		"raw":     [32]byte{1},
+		"ok":      true,
+		"name":    "sale",
+		"huge":    new(big.Int).Lsh(big.NewInt(1), 100),
+		"order":   Event{"id": big.NewInt(3), "maker": owner},
+		"items":   []Event{{"id": big.NewInt(1)}, {"id": big.NewInt(2)}},
+		"amounts": []*big.Int{big.NewInt(5), big.NewInt(6)},
+	}
+}
+
+func TestEventAccessors(t *testing.T) {
+	e := testEvent()
+	if v, err := e.Address("from"); err != nil || v != owner {
+		t.Errorf("Address() = %v, %v", v, err)
+	}
+	if v, err := e.BigInt("nonce"); err != nil || v.Int64() != 7 {
+		t.Errorf("BigInt() = %v, %v", v, err)
+	}
+	if v, err := e.Uint64("value"); err != nil || v != 1000 {
+		t.Errorf("Uint64() = %v, %v", v, err)
+	}
+	if v, err := e.Bytes32("raw"); err != nil || v != (common.Hash{1}) {
+		t.Errorf("Bytes32() = %v, %v", v, err)
+	}
+	if v, err := e.Bool("ok"); err != nil || !v {
+		t.Errorf("Bool() = %v, %v", v, err)
+	}
+	if v, err := e.String("name"); err != nil || v != "sale" {
+		t.Errorf("String() = %v, %v", v, err)
+	}
+
+	if _, err := e.Address("to"); !errors.Is(err, ErrMissingArgument) {
+		t.Errorf("missing Address() = %v", err)
+	}
+	if _, err := e.String("ok"); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("String() of a bool = %v", err)
+	}
+	if _, err := e.Uint64("huge"); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("Uint64() of 2^100 = %v", err)
+	}
+}
+
+func TestEventUnmarshal(t *testing.T) {
+	var v struct {
+		From   common.Address
+		Amount *big.Int `evm:"value"`
+		Nonce  uint64
+		Topic  [32]byte
+		Order  struct {
+			ID    int64
+			Maker common.Address
+		}
+		Items   []struct{ ID uint32 }
+		Amounts []uint64
+		Memo    string `evm:"memo,optional"`
+		Ignored string `evm:"-"`
+	}
+	if err := testEvent().Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.From != owner || v.Amount.Int64() != 1000 || v.Nonce != 7 || v.Topic != [32]byte(common.HexToHash("0x01")) {
+		t.Errorf("Unmarshal() = %+v", v)
+	}
+	if v.Order.ID != 3 || v.Order.Maker != owner || len(v.Items) != 2 || v.Items[1].ID != 2 {
+		t.Errorf("Unmarshal() tuples = %+v %+v", v.Order, v.Items)
+	}
+	if len(v.Amounts) != 2 || v.Amounts[1] != 6 {
+		t.Errorf("Unmarshal() amounts = %v", v.Amounts)
+	}
+
+	var missing struct{ To common.Address }
+	if err := testEvent().Unmarshal(&missing); !errors.Is(err, ErrMissingArgument) {
+		t.Errorf("Unmarshal() = %v, want ErrMissingArgument", err)
+	}
+	var wrong struct {
+		Order struct{ ID bool }
+	}
+	if err := testEvent().Unmarshal(&wrong); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("Unmarshal() = %v, want ErrArgumentType", err)
+	}
+}
+
+func TestEventUnmarshalCopies(t *testing.T) {
+	e := testEvent()
+	var v struct {
+		Value *big.Int
+	}
+	if err := e.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	v.Value.SetInt64(1)
+	if value, _ := e.BigInt("value"); value.Int64() != 1000 {
+		t.Errorf("changing the unmarshaled value changed the event to %v", value)
+	}
+	if value, _ := e.BigInt("value"); value == e["value"] {
+		t.Error("BigInt() returned the event's *big.Int")
+	}
+}
+
+func TestEventUnmarshalCase(t *testing.T) {
+	e := Event{"Amount": big.NewInt(1), "AMOUNT": big.NewInt(2), "amount": big.NewInt(3), "Fee": big.NewInt(4), "FEE": big.NewInt(5)}
+	var v struct {
+		Amount *big.Int
+		Fee    *big.Int `evm:",optional"`
+		FeE    *big.Int
+	}
+	for i := 0; i < 10; i++ {
+		if err := e.Unmarshal(&v); err != nil {
+			t.Fatal(err)
+		}
+		// Amount and Fee match exactly; FeE takes the first case-insensitive
+		// match in sort order, FEE.
+		if v.Amount.Int64() != 1 || v.Fee.Int64() != 4 || v.FeE.Int64() != 5 {
+			t.Fatalf("Unmarshal() = %v %v %v, want 1 4 5", v.Amount, v.Fee, v.FeE)
+		}
+	}
+}
@@ -7,6 +7,8 @@ type Template struct {
 	Name            string `gorm:"column:name;primaryKey" json:"name"`
 	ContractAddress string `gorm:"column:contract_address;primaryKey" json:"contract_address"`
 	EventName       string `gorm:"column:event_name;primaryKey" json:"event_name"`
+	Pipeline        string `gorm:"column:pipeline;not null" json:"pipeline"`
+	BlockNumber     int64  `gorm:"column:block_number;not null" json:"block_number"`
 }
 
 // TableName Template's table name
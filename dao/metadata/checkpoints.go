@@ -0,0 +1,21 @@
+package metadata
+
+import "time"
+
+const TableNameCheckpoint = "checkpoints"
+
+// Checkpoint mapped from table <checkpoints>. It records the last block fully
+// processed by a pipeline, or by one of its handlers when Handler is set.
+type Checkpoint struct {
+	Project     string    `gorm:"column:project;primaryKey" json:"project"`
+	Pipeline    string    `gorm:"column:pipeline;primaryKey" json:"pipeline"`
+	Handler     string    `gorm:"column:handler;primaryKey" json:"handler"`
+	BlockNumber int64     `gorm:"column:block_number;not null" json:"block_number"`
+	BlockHash   string    `gorm:"column:block_hash;not null" json:"block_hash"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;not null;type:timestamp" json:"updated_at"`
+}
+
+// TableName Checkpoint's table name
+func (*Checkpoint) TableName() string {
+	return TableNameCheckpoint
+}
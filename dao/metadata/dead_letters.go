@@ -0,0 +1,24 @@
+package metadata
+
+import "time"
+
+const TableNameDeadLetter = "dead_letters"
+
+// DeadLetter mapped from table <dead_letters>. It records a block a handler
+// kept failing on and that was processed without it, to be replayed once the
+// handler is fixed.
+type DeadLetter struct {
+	Project     string    `gorm:"column:project;primaryKey" json:"project"`
+	Pipeline    string    `gorm:"column:pipeline;primaryKey" json:"pipeline"`
+	Handler     string    `gorm:"column:handler;primaryKey" json:"handler"`
+	BlockNumber int64     `gorm:"column:block_number;primaryKey" json:"block_number"`
+	Kind        string    `gorm:"column:kind;not null" json:"kind"`
+	Error       string    `gorm:"column:error;not null;type:text" json:"error"`
+	Attempts    int       `gorm:"column:attempts;not null" json:"attempts"`
+	FailedAt    time.Time `gorm:"column:failed_at;not null;type:timestamp" json:"failed_at"`
+}
+
+// TableName DeadLetter's table name
+func (*DeadLetter) TableName() string {
+	return TableNameDeadLetter
+}
@@ -0,0 +1,20 @@
+package metadata
+
+import "time"
+
+const TableNameInitialization = "initializations"
+
+// Initialization mapped from table <initializations>. Each row records that
+// an initialization handler of a pipeline completed, so that it does not run
+// again.
+type Initialization struct {
+	Project     string    `gorm:"column:project;primaryKey" json:"project"`
+	Pipeline    string    `gorm:"column:pipeline;primaryKey" json:"pipeline"`
+	Handler     string    `gorm:"column:handler;primaryKey" json:"handler"`
+	CompletedAt time.Time `gorm:"column:completed_at;not null;type:timestamp" json:"completed_at"`
+}
+
+// TableName Initialization's table name
+func (*Initialization) TableName() string {
+	return TableNameInitialization
+}
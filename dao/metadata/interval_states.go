@@ -0,0 +1,28 @@
+package metadata
+
+import "time"
+
+const TableNameIntervalState = "interval_states"
+
+// IntervalState mapped from table <interval_states>. It records an interval
+// an interval handler fired for and the block it fired on, so that it fires
+// once per interval across restarts. Interval is the handler's interval, see
+// configs.IntervalHandler.Spec, so that a handler configured with several
+// intervals, or whose interval changed, has a state per interval. A handler
+// has one row per interval it fired for within the reorg depth: its state is
+// the row with the highest block number, and rolling back to a block restores
+// the state at that block.
+type IntervalState struct {
+	Project     string    `gorm:"column:project;primaryKey" json:"project"`
+	Pipeline    string    `gorm:"column:pipeline;primaryKey" json:"pipeline"`
+	Handler     string    `gorm:"column:handler;primaryKey" json:"handler"`
+	Interval    string    `gorm:"column:interval;primaryKey" json:"interval"`
+	BlockNumber int64     `gorm:"column:block_number;primaryKey" json:"block_number"`
+	LastBucket  int64     `gorm:"column:last_bucket;not null" json:"last_bucket"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;not null;type:timestamp" json:"updated_at"`
+}
+
+// TableName IntervalState's table name
+func (*IntervalState) TableName() string {
+	return TableNameIntervalState
+}
@@ -0,0 +1,70 @@
+package metadata
+
+import (
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"gorm.io/gorm"
+)
+
+// models lists the tables zsource keeps in the metadata schema.
+var models = []interface{}{
+	&evm.Template{},
+	&IntervalState{},
+	&SchemaVersion{},
+	&Checkpoint{},
+	&DeadLetter{},
+	&Initialization{},
+	&ProcessedBlock{},
+}
+
+// migrations upgrade the tables created by earlier versions, in order. They
+// run before AutoMigrate, which only creates missing tables and columns, and
+// can run again.
+var migrations = []string{
+	// The pipeline that registered a template address and the block it was
+	// registered at, so that a rollback of the pipeline reverts it.
+	`ALTER TABLE IF EXISTS templates ADD COLUMN IF NOT EXISTS pipeline text NOT NULL DEFAULT ''`,
+	`ALTER TABLE IF EXISTS templates ADD COLUMN IF NOT EXISTS block_number bigint NOT NULL DEFAULT 0`,
+}
+
+// keyMigrations change the primary keys of the tables once AutoMigrate has
+// created them. The generated models, such as evm.Template, keep the keys
+// they were generated with, so AutoMigrate creates new tables with those.
+// They can run again.
+var keyMigrations = []string{
+	// Pipelines register template addresses of their own, so that one does
+	// not listen to, or roll back, the addresses of another.
+	`DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT 1 FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = 'templates'::regclass AND i.indisprimary AND a.attname = 'pipeline'
+	) THEN
+		ALTER TABLE templates DROP CONSTRAINT IF EXISTS templates_pkey;
+		ALTER TABLE templates ADD PRIMARY KEY (name, contract_address, event_name, pipeline);
+	END IF;
+END $$`,
+}
+
+// Migrate creates the metadata tables if they do not exist yet and upgrades
+// the existing ones. The db is expected to resolve unqualified table names to
+// the metadata schema.
+func Migrate(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, migration := range migrations {
+			if err := tx.Exec(migration).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.AutoMigrate(models...); err != nil {
+			return err
+		}
+		for _, migration := range keyMigrations {
+			if err := tx.Exec(migration).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
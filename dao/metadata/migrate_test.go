@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/Zettablock/zsource/testutils/fakedb"
+)
+
+func TestMigrate(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db.DB); err != nil {
+		t.Fatal(err)
+	}
+	statements := db.Statements("")
+	if len(statements) < len(migrations)+len(keyMigrations)+2 || statements[0].SQL != "BEGIN" {
+		t.Fatalf("Migrate() ran %v", statements)
+	}
+	// The migrations upgrade the existing tables before AutoMigrate.
+	for i, migration := range migrations {
+		if got := statements[i+1].SQL; got != migration {
+			t.Errorf("statement %d = %s, want %s", i+1, got, migration)
+		}
+	}
+	if n := len(db.Statements(`CREATE TABLE "templates"`)); n != 1 {
+		t.Errorf("Migrate() created templates %d times, want 1", n)
+	}
+	// The primary keys are changed after AutoMigrate created the tables.
+	for i, migration := range keyMigrations {
+		if got := statements[len(statements)-len(keyMigrations)-1+i].SQL; got != migration {
+			t.Errorf("statement %d from the end = %s, want %s", len(keyMigrations)+1-i, got, migration)
+		}
+	}
+	if last := statements[len(statements)-1]; last.SQL != "COMMIT" {
+		t.Errorf("Migrate() ended with %s, want COMMIT", last.SQL)
+	}
+}
@@ -0,0 +1,19 @@
+package metadata
+
+const TableNameProcessedBlock = "processed_blocks"
+
+// ProcessedBlock mapped from table <processed_blocks>. It records the hashes
+// of the last blocks a pipeline processed, the chain its checkpoint is on, so
+// that chain reorganizations can be detected.
+type ProcessedBlock struct {
+	Project     string `gorm:"column:project;primaryKey" json:"project"`
+	Pipeline    string `gorm:"column:pipeline;primaryKey" json:"pipeline"`
+	BlockNumber int64  `gorm:"column:block_number;primaryKey" json:"block_number"`
+	BlockHash   string `gorm:"column:block_hash;not null" json:"block_hash"`
+	ParentHash  string `gorm:"column:parent_hash;not null" json:"parent_hash"`
+}
+
+// TableName ProcessedBlock's table name
+func (*ProcessedBlock) TableName() string {
+	return TableNameProcessedBlock
+}
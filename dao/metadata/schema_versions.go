@@ -0,0 +1,26 @@
+package metadata
+
+import "time"
+
+const TableNameSchemaVersion = "schema_versions"
+
+// SchemaVersion mapped from table <schema_versions>. Each row is a version of
+// the destination entities of a pipeline that was applied to the destination
+// schema Schema; Definition holds the entities as JSON.
+type SchemaVersion struct {
+	Project    string    `gorm:"column:project;primaryKey" json:"project"`
+	Pipeline   string    `gorm:"column:pipeline;primaryKey" json:"pipeline"`
+	Version    int64     `gorm:"column:version;primaryKey" json:"version"`
+	Schema     string    `gorm:"column:schema;not null" json:"schema"`
+	Definition string    `gorm:"column:definition;not null;type:text" json:"definition"`
+	AppliedAt  time.Time `gorm:"column:applied_at;not null;type:timestamp" json:"applied_at"`
+	// Pending is set while the version is being applied to a destination db
+	// other than the metadata db. A version left pending may not have been
+	// applied.
+	Pending bool `gorm:"column:pending;not null;default:false" json:"pending"`
+}
+
+// TableName SchemaVersion's table name
+func (*SchemaVersion) TableName() string {
+	return TableNameSchemaVersion
+}
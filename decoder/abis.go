@@ -0,0 +1,115 @@
+package decoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Zettablock/zsource/configs"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// LoadABIs merges the ABI files called names in dir, or every .json file in
+// it when names is empty, such as the abis directory of utils.Deps.ABIDir,
+// into one ABI. Logs and calls are matched by event ID and selector, so the
+// merged ABI decodes those of any of the contracts; the first file wins
+// when two declare the same signature, and overloads across files are
+// renamed the way go-ethereum renames them within one, e.g. "transfer0".
+// Events with the same signature but different indexed arguments, such as
+// the Transfer events of ERC-20 and ERC-721, are all kept: the Decoder tells
+// their logs apart by their number of topics.
+func LoadABIs(dir string, names ...string) (*abi.ABI, error) {
+	if len(names) == 0 {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no abi in %s", dir)
+		}
+	}
+	merged := &abi.ABI{
+		Methods: map[string]abi.Method{},
+		Events:  map[string]abi.Event{},
+		Errors:  map[string]abi.Error{},
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		contractAbi, err := configs.LoadABIFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, method := range sorted(contractAbi.Methods) {
+			if !seen["function "+method.Sig] {
+				seen["function "+method.Sig] = true
+				method.Name = abi.ResolveNameConflict(method.RawName, func(name string) bool {
+					_, ok := merged.Methods[name]
+					return ok
+				})
+				merged.Methods[method.Name] = method
+			}
+		}
+		for _, event := range sorted(contractAbi.Events) {
+			if key := eventKey(event); !seen[key] {
+				seen[key] = true
+				event.Name = abi.ResolveNameConflict(event.RawName, func(name string) bool {
+					_, ok := merged.Events[name]
+					return ok
+				})
+				merged.Events[event.Name] = event
+			}
+		}
+		for _, e := range sorted(contractAbi.Errors) {
+			if !seen["error "+e.Sig] {
+				seen["error "+e.Sig] = true
+				e.Name = abi.ResolveNameConflict(e.Name, func(name string) bool {
+					_, ok := merged.Errors[name]
+					return ok
+				})
+				merged.Errors[e.Name] = e
+			}
+		}
+	}
+	return merged, nil
+}
+
+// eventKey identifies the events that decode the same logs: those with the
+// same signature and indexed arguments.
+func eventKey(event abi.Event) string {
+	var indexed strings.Builder
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed.WriteByte('i')
+		} else {
+			indexed.WriteByte('-')
+		}
+	}
+	if event.Anonymous {
+		indexed.WriteString(" anonymous")
+	}
+	return "event " + event.Sig + " " + indexed.String()
+}
+
+// sorted returns the values of m ordered by key, so that overloads are
+// renamed the same way every time.
+func sorted[T any](m map[string]T) []T {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]T, len(keys))
+	for i, key := range keys {
+		values[i] = m[key]
+	}
+	return values
+}
@@ -0,0 +1,418 @@
+package decoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Zettablock/zsource/dao/base"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNotDecoded is returned for rows that upstream did not decode when there
+// is no ABI to decode them with.
+var ErrNotDecoded = errors.New("not decoded")
+
+// FromArguments converts the ArgumentNames, ArgumentTypes and ArgumentValues
+// columns of rows decoded upstream into an evm.Event with the same values
+// DecodeEvent returns. Values are parsed according to their Solidity type:
+// strings as they are, integers in decimal or 0x hex, addresses and bytes in 0x hex, arrays and
+// tuples as JSON arrays or as bracketed, comma separated lists. Tuple types
+// are written "tuple(uint256 id,address owner)" or "(uint256,address)";
+// unnamed components are named "arg<i>" and tuple values may also be JSON
+// objects keyed by component name.
+func FromArguments(names []string, types []string, values []string) (evm.Event, error) {
+	if len(names) != len(types) || len(names) != len(values) {
+		return nil, fmt.Errorf("%d argument names, %d types and %d values", len(names), len(types), len(values))
+	}
+	event := make(evm.Event, len(names))
+	for i, name := range names {
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		t, err := ParseType(types[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %w", name, err)
+		}
+		v, err := parseValue(values[i], t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %w", name, err)
+		}
+		event[name] = v
+	}
+	return event, nil
+}
+
+// LogArguments returns the arguments of log: from its argument columns when
+// upstream decoded it with an ABI, or else decoded with d. d may be nil, in
+// which case logs not decoded upstream return ErrNotDecoded.
+func (d *Decoder) LogArguments(log *ethereum.Log) (evm.Event, error) {
+	if log.DecodedFromAbi {
+		return FromArguments(log.ArgumentNames, log.ArgumentTypes, log.ArgumentValues)
+	}
+	if d == nil {
+		return nil, ErrNotDecoded
+	}
+	_, event, err := d.DecodeLog(log)
+	return event, err
+}
+
+// BaseLogArguments is LogArguments for base.Log.
+func (d *Decoder) BaseLogArguments(log *base.Log) (evm.Event, error) {
+	if log.DecodedFromAbi {
+		return FromArguments(log.ArgumentNames, log.ArgumentTypes, log.ArgumentValues)
+	}
+	if d == nil {
+		return nil, ErrNotDecoded
+	}
+	_, event, err := d.DecodeBaseLog(log)
+	return event, err
+}
+
+// TransactionArguments is LogArguments for the input of a transaction.
+func (d *Decoder) TransactionArguments(tx *ethereum.Transaction) (evm.Event, error) {
+	if tx.DecodedFromAbi {
+		return FromArguments(tx.ArgumentNames, tx.ArgumentTypes, tx.ArgumentValues)
+	}
+	if d == nil {
+		return nil, ErrNotDecoded
+	}
+	_, event, err := d.DecodeInput(tx.Input)
+	return event, err
+}
+
+// TraceArguments is LogArguments for the input of a trace.
+func (d *Decoder) TraceArguments(trace *ethereum.Trace) (evm.Event, error) {
+	if trace.DecodedFromAbi {
+		return FromArguments(trace.ArgumentNames, trace.ArgumentTypes, trace.ArgumentValues)
+	}
+	if d == nil {
+		return nil, ErrNotDecoded
+	}
+	_, event, err := d.DecodeInput(trace.Input)
+	return event, err
+}
+
+// TraceOutputs is LogArguments for the return values of a trace, read from
+// its OutputNames, OutputTypes and OutputValues columns.
+func (d *Decoder) TraceOutputs(trace *ethereum.Trace) (evm.Event, error) {
+	if trace.DecodedFromAbi {
+		return FromArguments(trace.OutputNames, trace.OutputTypes, trace.OutputValues)
+	}
+	if d == nil {
+		return nil, ErrNotDecoded
+	}
+	_, event, err := d.DecodeOutput(trace.Input, trace.Output)
+	return event, err
+}
+
+// ParseType parses a Solidity type string, including tuple types as
+// described on FromArguments.
+func ParseType(s string) (abi.Type, error) {
+	typ, components, err := parseTypeString(s)
+	if err != nil {
+		return abi.Type{}, err
+	}
+	return abi.NewType(typ, "", components)
+}
+
+// parseTypeString splits tuple types into the "tuple[...]" form and the
+// components go-ethereum expects.
+func parseTypeString(s string) (string, []abi.ArgumentMarshaling, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") && !strings.HasPrefix(s, "tuple(") {
+		return intAlias.ReplaceAllString(s, "${0}256"), nil, nil
+	}
+	open := strings.IndexByte(s, '(')
+	end := closing(s, open)
+	if end < 0 {
+		return "", nil, fmt.Errorf("malformed tuple type %q", s)
+	}
+	var components []abi.ArgumentMarshaling
+	if inner := strings.TrimSpace(s[open+1 : end]); inner != "" {
+		for i, part := range split(inner, ',') {
+			fields := split(strings.TrimSpace(part), ' ')
+			typ, nested, err := parseTypeString(fields[0])
+			if err != nil {
+				return "", nil, err
+			}
+			name := fmt.Sprintf("arg%d", i)
+			if len(fields) > 1 && fields[len(fields)-1] != "" {
+				name = fields[len(fields)-1]
+			}
+			components = append(components, abi.ArgumentMarshaling{Name: name, Type: typ, Components: nested})
+		}
+	}
+	return "tuple" + s[end+1:], components, nil
+}
+
+// intAlias matches the int and uint aliases of int256 and uint256.
+var intAlias = regexp.MustCompile(`\bu?int\b`)
+
+// parseValue parses the string form of a value of type t.
+func parseValue(s string, t abi.Type) (any, error) {
+	if t.T != abi.StringTy {
+		// Strings are taken as they are, surrounding spaces included.
+		s = strings.TrimSpace(s)
+	}
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		n, ok := parseInteger(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q", t, s)
+		}
+		if !inRange(n, t) {
+			return nil, fmt.Errorf("%s out of %s range", s, t)
+		}
+		if rt := t.GetType(); rt != reflect.TypeOf(n) {
+			v := reflect.New(rt).Elem()
+			if t.T == abi.IntTy {
+				v.SetInt(n.Int64())
+			} else {
+				v.SetUint(n.Uint64())
+			}
+			return v.Interface(), nil
+		}
+		return n, nil
+	case abi.BoolTy:
+		return strconv.ParseBool(s)
+	case abi.StringTy:
+		return s, nil
+	case abi.AddressTy:
+		if !common.IsHexAddress(s) {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		return common.HexToAddress(s), nil
+	case abi.BytesTy:
+		return decodeHex(s)
+	case abi.FixedBytesTy:
+		b, err := decodeHex(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > t.Size {
+			return nil, fmt.Errorf("%d bytes for %s", len(b), t)
+		}
+		v := reflect.New(t.GetType()).Elem()
+		reflect.Copy(v, reflect.ValueOf(b))
+		return v.Interface(), nil
+	case abi.SliceTy, abi.ArrayTy:
+		elems, err := elements(s)
+		if err != nil {
+			return nil, err
+		}
+		if t.T == abi.ArrayTy && len(elems) != t.Size {
+			return nil, fmt.Errorf("%d elements for %s", len(elems), t)
+		}
+		return parseList(elems, t)
+	case abi.TupleTy:
+		return parseTuple(s, t)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// parseInteger parses a decimal integer, or a hex one with a 0x prefix,
+// either with an optional sign. Unlike big.Int.SetString with base 0, it does
+// not read a leading 0 as octal nor accept underscores.
+func parseInteger(s string) (*big.Int, bool) {
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return nil, false
+	}
+	base := 10
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		base, digits = 16, digits[2:]
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, false
+	}
+	if strings.HasPrefix(s, "-") {
+		n.Neg(n)
+	}
+	return n, true
+}
+
+// inRange reports whether n fits in the integer type t.
+func inRange(n *big.Int, t abi.Type) bool {
+	if t.T == abi.UintTy {
+		return n.Sign() >= 0 && n.BitLen() <= t.Size
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+	return n.Cmp(limit) < 0 && n.Cmp(limit.Neg(limit)) >= 0
+}
+
+// parseList parses the elements of an array of type t, into the same types
+// convert returns for decoded arrays.
+func parseList(elems []string, t abi.Type) (any, error) {
+	if hasTuple(*t.Elem) {
+		values := make([]any, len(elems))
+		tuples := make([]evm.Event, len(elems))
+		for i, elem := range elems {
+			v, err := parseValue(elem, *t.Elem)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			values[i] = v
+			if tuple, ok := v.(evm.Event); ok {
+				tuples[i] = tuple
+			}
+		}
+		if t.Elem.T == abi.TupleTy {
+			return tuples, nil
+		}
+		return values, nil
+	}
+	list := reflect.New(t.GetType()).Elem()
+	if t.T == abi.SliceTy {
+		list = reflect.MakeSlice(t.GetType(), len(elems), len(elems))
+	}
+	for i, elem := range elems {
+		v, err := parseValue(elem, *t.Elem)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		list.Index(i).Set(reflect.ValueOf(v))
+	}
+	return list.Interface(), nil
+}
+
+func parseTuple(s string, t abi.Type) (evm.Event, error) {
+	tuple := make(evm.Event, len(t.TupleElems))
+	if strings.HasPrefix(s, "{") {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(s), &fields); err != nil {
+			return nil, fmt.Errorf("invalid tuple %q: %w", s, err)
+		}
+		for i, name := range t.TupleRawNames {
+			raw, ok := fields[name]
+			if !ok {
+				return nil, fmt.Errorf("tuple %q has no %s", s, name)
+			}
+			v, err := parseValue(jsonElement(raw), *t.TupleElems[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			tuple[name] = v
+		}
+		return tuple, nil
+	}
+	elems, err := elements(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) != len(t.TupleElems) {
+		return nil, fmt.Errorf("%d elements for %s", len(elems), t)
+	}
+	for i, name := range t.TupleRawNames {
+		v, err := parseValue(elems[i], *t.TupleElems[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		tuple[name] = v
+	}
+	return tuple, nil
+}
+
+// elements splits the string form of an array or tuple into the string
+// forms of its elements.
+func elements(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &raw); err == nil {
+			elems := make([]string, len(raw))
+			for i, r := range raw {
+				elems[i] = jsonElement(r)
+			}
+			return elems, nil
+		}
+	}
+	if len(s) < 2 || closing(s, 0) != len(s)-1 {
+		return nil, fmt.Errorf("malformed list %q", s)
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return nil, nil
+	}
+	elems := split(inner, ',')
+	for i, elem := range elems {
+		elems[i] = unquote(strings.TrimSpace(elem))
+	}
+	return elems, nil
+}
+
+// jsonElement returns JSON strings unquoted and other JSON values as is.
+func jsonElement(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// closing returns the index of the bracket closing the one at open, or -1.
+func closing(s string, open int) int {
+	depth := 0
+	quote := byte(0)
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// split splits s on sep outside of brackets and quotes.
+func split(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,101 @@
+package decoder
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestFromArguments(t *testing.T) {
+	names := []string{"from", "value", "small", "delta", "flag", "memo", "digest", "data", "owners", "grid", "order", "items", "", "padded", "zeros"}
+	types := []string{
+		"address", "uint", "uint8", "int16", "bool", "string", "bytes32", "bytes", "address[]", "uint256[2][]",
+		"tuple(uint256 id,address owner)", "(uint64,bool)[]", "int256", "string", "uint256",
+	}
+	values := []string{
+		alice.Hex(), "1000", "0x07", "-300", "true", "hello, world", "0x01", "0xcafe",
+		`["` + alice.Hex() + `","` + bob.Hex() + `"]`, "[[1,2],[3,4]]",
+		`{"id":"5","owner":"` + bob.Hex() + `"}`, "[(1,true),(2,false)]", "-1", " spaced\n", " 0010 ",
+	}
+	got, err := FromArguments(names, types, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := evm.Event{
+		"from":   alice,
+		"value":  big.NewInt(1000),
+		"small":  uint8(7),
+		"delta":  int16(-300),
+		"flag":   true,
+		"memo":   "hello, world",
+		"digest": [32]byte{1},
+		"data":   []byte{0xca, 0xfe},
+		"owners": []common.Address{alice, bob},
+		"grid":   [][2]*big.Int{{big.NewInt(1), big.NewInt(2)}, {big.NewInt(3), big.NewInt(4)}},
+		"order":  evm.Event{"id": big.NewInt(5), "owner": bob},
+		"items":  []evm.Event{{"arg0": uint64(1), "arg1": true}, {"arg0": uint64(2), "arg1": false}},
+		"arg12":  big.NewInt(-1),
+		"padded": " spaced\n",
+		"zeros":  big.NewInt(10),
+	}
+	for name, v := range want {
+		if !reflect.DeepEqual(got[name], v) {
+			t.Errorf("%s = %#v, want %#v", name, got[name], v)
+		}
+	}
+
+	for _, tt := range []struct{ typ, value string }{
+		{"uint8", "256"},
+		{"int8", "-129"},
+		{"uint256", "-1"},
+		{"uint256", "1_000"},
+		{"uint256", "0b101"},
+		{"int256", "--1"},
+		{"uint256", "0x"},
+		{"address", "0x12"},
+		{"uint256[2]", "[1]"},
+		{"(uint256,bool)", "(1)"},
+	} {
+		if _, err := FromArguments([]string{"a"}, []string{tt.typ}, []string{tt.value}); err == nil {
+			t.Errorf("FromArguments(%s %s) succeeded", tt.typ, tt.value)
+		}
+	}
+}
+
+func TestLogArguments(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	log := &ethereum.Log{
+		Topics: []string{
+			contractAbi.Events["Transfer"].ID.Hex(),
+			common.BytesToHash(alice.Bytes()).Hex(),
+			common.BytesToHash(bob.Bytes()).Hex(),
+		},
+		Data: pack(t, contractAbi.Events["Transfer"], big.NewInt(1000)),
+	}
+	decoded, err := d.LogArguments(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (*Decoder)(nil).LogArguments(log); !errors.Is(err, ErrNotDecoded) {
+		t.Errorf("LogArguments() without abi = %v, want ErrNotDecoded", err)
+	}
+
+	// Upstream decoding gives the same values.
+	log.DecodedFromAbi = true
+	log.ArgumentNames = []string{"from", "to", "value"}
+	log.ArgumentTypes = []string{"address", "address", "uint256"}
+	log.ArgumentValues = []string{alice.Hex(), bob.Hex(), "1000"}
+	upstream, err := (*Decoder)(nil).LogArguments(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(upstream, decoded) {
+		t.Errorf("upstream arguments %v, decoded %v", upstream, decoded)
+	}
+}
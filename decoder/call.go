@@ -0,0 +1,107 @@
+package decoder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var ErrUnknownMethod = errors.New("no abi method matches the selector")
+
+// DecodeInput decodes the input of a transaction or trace into the method it
+// calls, identified by the 4-byte selector, and its arguments, keyed by
+// argument name ("arg<i>" for unnamed ones), with values as for DecodeEvent.
+func (d *Decoder) DecodeInput(input string) (*abi.Method, evm.Event, error) {
+	data, err := decodeHex(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("%w: input %q has no selector", ErrUnknownMethod, input)
+	}
+	method, err := d.abi.MethodById(data[:4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownMethod, hexutil.Encode(data[:4]))
+	}
+	values, err := unpackArguments(method.Inputs, data[4:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("method %s: %w", method.Sig, err)
+	}
+	return method, values, nil
+}
+
+// DecodeOutput decodes the output of a successful call into the return
+// values of the method its input calls, keyed and typed as the arguments
+// DecodeInput returns.
+func (d *Decoder) DecodeOutput(input string, output string) (*abi.Method, evm.Event, error) {
+	method, _, err := d.DecodeInput(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := decodeHex(output)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := unpackArguments(method.Outputs, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("method %s output: %w", method.Sig, err)
+	}
+	return method, values, nil
+}
+
+// DecodeTransaction fills the FuncName, FuncSignature and argument columns of
+// tx from its input, as upstream does for the contracts it has the ABI of,
+// and sets DecodedFromAbi. Transactions upstream decoded are left alone.
+func (d *Decoder) DecodeTransaction(tx *ethereum.Transaction) error {
+	if tx.DecodedFromAbi {
+		return nil
+	}
+	method, values, err := d.DecodeInput(tx.Input)
+	if err != nil {
+		return err
+	}
+	names, types, formatted, err := ToArguments(method.Inputs, values)
+	if err != nil {
+		return err
+	}
+	tx.FuncName, tx.FuncSignature = method.RawName, method.Sig
+	tx.ArgumentNames, tx.ArgumentTypes, tx.ArgumentValues = names, types, formatted
+	tx.DecodedFromAbi = true
+	return nil
+}
+
+// DecodeTrace is DecodeTransaction for traces. It also fills the output
+// columns with the return values of successful calls.
+func (d *Decoder) DecodeTrace(trace *ethereum.Trace) error {
+	if trace.DecodedFromAbi {
+		return nil
+	}
+	method, values, err := d.DecodeInput(trace.Input)
+	if err != nil {
+		return err
+	}
+	names, types, formatted, err := ToArguments(method.Inputs, values)
+	if err != nil {
+		return err
+	}
+	var outputNames, outputTypes, outputValues []string
+	if trace.Status == 1 && len(method.Outputs) > 0 {
+		_, outputs, err := d.DecodeOutput(trace.Input, trace.Output)
+		if err != nil {
+			return err
+		}
+		if outputNames, outputTypes, outputValues, err = ToArguments(method.Outputs, outputs); err != nil {
+			return err
+		}
+	}
+	trace.FuncName, trace.FuncSignature = method.RawName, method.Sig
+	trace.ArgumentNames, trace.ArgumentTypes, trace.ArgumentValues = names, types, formatted
+	trace.OutputNames, trace.OutputTypes, trace.OutputValues = outputNames, outputTypes, outputValues
+	trace.DecodedFromAbi = true
+	return nil
+}
@@ -0,0 +1,83 @@
+package decoder
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+)
+
+func TestDecodeInput(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	method, values, err := d.DecodeInput(callInput(t, contractAbi, "transfer", bob, big.NewInt(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := evm.Event{"to": bob, "arg1": big.NewInt(7)}
+	if method.Name != "transfer" || !reflect.DeepEqual(values, want) {
+		t.Errorf("DecodeInput() = %s %v, want transfer %v", method.Name, values, want)
+	}
+	for _, input := range []string{"0x", "0x12345678"} {
+		if _, _, err := d.DecodeInput(input); !errors.Is(err, ErrUnknownMethod) {
+			t.Errorf("DecodeInput(%s) error = %v, want ErrUnknownMethod", input, err)
+		}
+	}
+}
+
+func TestDecodeOutput(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	input := callInput(t, contractAbi, "balances", alice)
+	method, values, err := d.DecodeOutput(input, callOutput(t, contractAbi, "balances", big.NewInt(5), big.NewInt(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := evm.Event{"free": big.NewInt(5), "locked": big.NewInt(2)}
+	if method.Name != "balances" || !reflect.DeepEqual(values, want) {
+		t.Errorf("DecodeOutput() = %s %v, want balances %v", method.Name, values, want)
+	}
+	if _, _, err := d.DecodeOutput(input, "0x"); err == nil {
+		t.Error("DecodeOutput() of an empty output succeeded")
+	}
+}
+
+func TestDecodeTrace(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	trace := &ethereum.Trace{
+		Input:  callInput(t, contractAbi, "transfer", bob, big.NewInt(7)),
+		Output: callOutput(t, contractAbi, "transfer", true),
+		Status: 1,
+	}
+	if err := d.DecodeTrace(trace); err != nil {
+		t.Fatal(err)
+	}
+	if trace.FuncName != "transfer" || trace.FuncSignature != "transfer(address,uint256)" || !trace.DecodedFromAbi {
+		t.Errorf("DecodeTrace() set %s %s %v", trace.FuncName, trace.FuncSignature, trace.DecodedFromAbi)
+	}
+	if got := []string(trace.ArgumentValues); !reflect.DeepEqual(got, []string{strings.ToLower(bob.Hex()), "7"}) {
+		t.Errorf("ArgumentValues = %v", got)
+	}
+	if got := []string(trace.OutputValues); !reflect.DeepEqual(got, []string{"true"}) || trace.OutputNames[0] != "arg0" || trace.OutputTypes[0] != "bool" {
+		t.Errorf("outputs = %v %v %v, want [arg0] [bool] [true]", trace.OutputNames, trace.OutputTypes, got)
+	}
+
+	// The columns now decode without the ABI.
+	outputs, err := (*Decoder)(nil).TraceOutputs(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(outputs, evm.Event{"arg0": true}) {
+		t.Errorf("TraceOutputs() = %v", outputs)
+	}
+
+	failed := &ethereum.Trace{Input: trace.Input, Error: "Reverted"}
+	if err := d.DecodeTrace(failed); err != nil {
+		t.Fatal(err)
+	}
+	if len(failed.ArgumentValues) != 2 || len(failed.OutputValues) != 0 {
+		t.Errorf("DecodeTrace() of a failed trace set %v and %v", failed.ArgumentValues, failed.OutputValues)
+	}
+}
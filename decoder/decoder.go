@@ -0,0 +1,194 @@
+// Package decoder decodes the logs of contracts and the calls to them into
+// evm.Event values with the contracts' ABIs, as loaded by
+// utils.Deps.LoadABIByName.
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Zettablock/zsource/dao/base"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	ErrUnknownEvent = errors.New("no abi event matches the log")
+	ErrMalformed    = errors.New("malformed hex data")
+)
+
+// Decoder decodes the logs of a contract with its ABI.
+type Decoder struct {
+	abi *abi.ABI
+	// events lists the events of the ABI by ID, ordered by name. An ID has
+	// several events when they only differ in which arguments are indexed,
+	// such as the Transfer events of ERC-20 and ERC-721 in a merged ABI.
+	events map[common.Hash][]*abi.Event
+	// anonymous lists the anonymous events of the ABI by name. Their logs are
+	// not identified by topic 0 and are matched by trying each in turn.
+	anonymous []*abi.Event
+}
+
+func New(contractAbi *abi.ABI) *Decoder {
+	d := &Decoder{abi: contractAbi, events: map[common.Hash][]*abi.Event{}}
+	names := make([]string, 0, len(contractAbi.Events))
+	for name := range contractAbi.Events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		event := contractAbi.Events[name]
+		if event.Anonymous {
+			d.anonymous = append(d.anonymous, &event)
+		} else {
+			d.events[event.ID] = append(d.events[event.ID], &event)
+		}
+	}
+	return d
+}
+
+// DecodeLog decodes log, see DecodeEvent.
+func (d *Decoder) DecodeLog(log *ethereum.Log) (*abi.Event, evm.Event, error) {
+	return d.DecodeEvent(log.Topics, log.Data, log.Anonymous)
+}
+
+// DecodeBaseLog decodes log, see DecodeEvent.
+func (d *Decoder) DecodeBaseLog(log *base.Log) (*abi.Event, evm.Event, error) {
+	return d.DecodeEvent(log.Topics, log.Data, log.Anonymous)
+}
+
+// DecodeEvent decodes the topics and data of a log into the event they were
+// emitted for and its arguments, keyed by argument name ("arg<i>" for
+// unnamed ones). Topic 0 identifies the event unless the log is anonymous,
+// along with the number of topics when events share an ID; a log no event ID
+// matches is tried against the anonymous events of the ABI, and
+// ErrUnknownEvent is returned if none fits.
+//
+// Values are native Go values: *big.Int for integers wider than 64 bits,
+// common.Address, []byte, [N]byte, and evm.Event for tuples, []evm.Event for
+// arrays of tuples. Indexed strings, bytes, arrays and tuples are only
+// logged as the keccak256 hash of their value, which is returned as a
+// common.Hash.
+func (d *Decoder) DecodeEvent(topics []string, data string, anonymous bool) (*abi.Event, evm.Event, error) {
+	hashes := make([]common.Hash, 0, len(topics))
+	for _, topic := range topics {
+		b, err := decodeHex(topic)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(b) != common.HashLength {
+			return nil, nil, fmt.Errorf("%w: topic %s is not 32 bytes", ErrMalformed, topic)
+		}
+		hashes = append(hashes, common.BytesToHash(b))
+	}
+	raw, err := decodeHex(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !anonymous && len(hashes) > 0 {
+		var firstErr error
+		for _, event := range d.events[hashes[0]] {
+			values, err := unpackEvent(event, hashes[1:], raw)
+			if err == nil {
+				return event, values, nil
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("event %s: %w", event.Sig, err)
+			}
+		}
+		if firstErr != nil {
+			return nil, nil, firstErr
+		}
+	}
+	for _, event := range d.anonymous {
+		if values, err := unpackEvent(event, hashes, raw); err == nil {
+			return event, values, nil
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, nil, ErrUnknownEvent
+	}
+	return nil, nil, fmt.Errorf("%w: topic %s", ErrUnknownEvent, hashes[0].Hex())
+}
+
+// unpackEvent decodes the indexed arguments of event from topics, without
+// the event ID, and the others from data.
+func unpackEvent(event *abi.Event, topics []common.Hash, data []byte) (evm.Event, error) {
+	var indexed abi.Arguments
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed = append(indexed, arg)
+		}
+	}
+	if len(topics) != len(indexed) {
+		return nil, fmt.Errorf("%d topics for %d indexed arguments", len(topics), len(indexed))
+	}
+
+	values := evm.Event{}
+	for i, arg := range indexed {
+		if hashed(arg.Type) {
+			values[arg.Name] = topics[i]
+			continue
+		}
+		if err := abi.ParseTopicsIntoMap(values, abi.Arguments{arg}, topics[i:i+1]); err != nil {
+			return nil, fmt.Errorf("argument %s: %w", arg.Name, err)
+		}
+	}
+
+	unpacked, err := unpackArguments(event.Inputs.NonIndexed(), data)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range unpacked {
+		values[name] = v
+	}
+	return values, nil
+}
+
+// unpackArguments decodes data, the ABI encoding of args.
+func unpackArguments(args abi.Arguments, data []byte) (evm.Event, error) {
+	unpacked, err := args.Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	values := make(evm.Event, len(args))
+	for i, arg := range args {
+		values[argumentName(arg, i)] = convert(unpacked[i], arg.Type)
+	}
+	return values, nil
+}
+
+// argumentName returns the name of the i-th argument, "arg<i>" if it has
+// none.
+func argumentName(arg abi.Argument, i int) string {
+	if arg.Name == "" {
+		return fmt.Sprintf("arg%d", i)
+	}
+	return arg.Name
+}
+
+// hashed reports whether indexed arguments of type t are logged as the hash
+// of their value.
+func hashed(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	}
+	return false
+}
+
+func decodeHex(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return b, nil
+}
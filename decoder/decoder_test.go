@@ -0,0 +1,84 @@
+package decoder
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDecodeLog(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	log := &ethereum.Log{
+		Topics: []string{
+			contractAbi.Events["Transfer"].ID.Hex(),
+			common.BytesToHash(alice.Bytes()).Hex(),
+			common.BytesToHash(bob.Bytes()).Hex(),
+		},
+		Data: pack(t, contractAbi.Events["Transfer"], big.NewInt(1000)),
+	}
+	event, values, err := d.DecodeLog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := evm.Event{"from": alice, "to": bob, "value": big.NewInt(1000)}
+	if event.Name != "Transfer" || !reflect.DeepEqual(values, want) {
+		t.Errorf("DecodeLog() = %s %v, want Transfer %v", event.Name, values, want)
+	}
+}
+
+func TestDecodeEventTuplesAndHashedTopics(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	listed := contractAbi.Events["Listed"]
+	type item struct {
+		Id    *big.Int
+		Owner common.Address
+	}
+	data := pack(t, listed, []item{{big.NewInt(1), alice}, {big.NewInt(2), bob}}, []byte{0xca, 0xfe})
+	nameHash := crypto.Keccak256Hash([]byte("sale"))
+
+	event, values, err := d.DecodeEvent([]string{listed.ID.Hex(), nameHash.Hex()}, data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := evm.Event{
+		"name": nameHash,
+		"items": []evm.Event{
+			{"id": big.NewInt(1), "owner": alice},
+			{"id": big.NewInt(2), "owner": bob},
+		},
+		"arg2": []byte{0xca, 0xfe},
+	}
+	if event.Name != "Listed" || !reflect.DeepEqual(values, want) {
+		t.Errorf("DecodeEvent() = %s %v, want Listed %v", event.Name, values, want)
+	}
+}
+
+func TestDecodeAnonymousEvent(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	seq := common.BigToHash(big.NewInt(7))
+	event, values, err := d.DecodeEvent([]string{seq.Hex()}, pack(t, contractAbi.Events["Ping"], true), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (evm.Event{"seq": uint64(7), "ok": true}); event.Name != "Ping" || !reflect.DeepEqual(values, want) {
+		t.Errorf("DecodeEvent() = %s %v, want Ping %v", event.Name, values, want)
+	}
+}
+
+func TestDecodeUnknownEvent(t *testing.T) {
+	d, _ := testDecoder(t)
+	unknown := crypto.Keccak256Hash([]byte("Unknown()"))
+	if _, _, err := d.DecodeEvent([]string{unknown.Hex()}, "0x", false); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("DecodeEvent() = %v, want ErrUnknownEvent", err)
+	}
+	if _, _, err := d.DecodeEvent([]string{"0x12"}, "0x", false); !errors.Is(err, ErrMalformed) {
+		t.Errorf("DecodeEvent() = %v, want ErrMalformed", err)
+	}
+}
@@ -0,0 +1,122 @@
+package decoder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// testABI is the ABI the decoder tests share: events, including tuples and an
+// anonymous one, functions with unnamed and several outputs, and a custom
+// error.
+const testABI = `[
+	{"type": "event", "name": "Transfer", "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "value", "type": "uint256", "indexed": false}
+	]},
+	{"type": "event", "name": "Listed", "inputs": [
+		{"name": "name", "type": "string", "indexed": true},
+		{"name": "items", "type": "tuple[]", "indexed": false, "components": [
+			{"name": "id", "type": "uint256"},
+			{"name": "owner", "type": "address"}
+		]},
+		{"name": "", "type": "bytes", "indexed": false}
+	]},
+	{"type": "event", "name": "Ping", "anonymous": true, "inputs": [
+		{"name": "seq", "type": "uint64", "indexed": true},
+		{"name": "ok", "type": "bool", "indexed": false}
+	]},
+	{"type": "function", "name": "transfer", "inputs": [
+		{"name": "to", "type": "address"},
+		{"name": "", "type": "uint256"}
+	], "outputs": [{"name": "", "type": "bool"}]},
+	{"type": "function", "name": "balances", "inputs": [
+		{"name": "owner", "type": "address"}
+	], "outputs": [
+		{"name": "free", "type": "uint256"},
+		{"name": "locked", "type": "uint128"}
+	]},
+	{"type": "error", "name": "InsufficientBalance", "inputs": [
+		{"name": "available", "type": "uint256"},
+		{"name": "required", "type": "uint256"}
+	]}
+]`
+
+// testNFTABI has the ERC-721 Transfer event, whose ID is the one of the
+// ERC-20 Transfer of testABI.
+const testNFTABI = `[
+	{"type": "event", "name": "Transfer", "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "tokenId", "type": "uint256", "indexed": true}
+	]}
+]`
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	bob   = common.HexToAddress("0x00000000000000000000000000000000000000b0")
+)
+
+func testDecoder(t *testing.T) (*Decoder, *abi.ABI) {
+	t.Helper()
+	contractAbi, err := abi.JSON(strings.NewReader(testABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(&contractAbi), &contractAbi
+}
+
+// writeABIs writes files, keyed by name, to a new abis directory.
+func writeABIs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// pack returns the data of a log of event with the non-indexed args.
+func pack(t *testing.T, event abi.Event, args ...any) string {
+	t.Helper()
+	data, err := event.Inputs.NonIndexed().Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(data)
+}
+
+func callInput(t *testing.T, contractAbi *abi.ABI, method string, args ...any) string {
+	t.Helper()
+	data, err := contractAbi.Pack(method, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(data)
+}
+
+func callOutput(t *testing.T, contractAbi *abi.ABI, method string, values ...any) string {
+	t.Helper()
+	data, err := contractAbi.Methods[method].Outputs.Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(data)
+}
+
+func revertData(t *testing.T, e interface{ Pack(...any) ([]byte, error) }, selector []byte, args ...any) string {
+	t.Helper()
+	data, err := e.Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(append(append([]byte{}, selector...), data...))
+}
@@ -0,0 +1,143 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ToArguments formats the values of args in event into argument name, type
+// and value columns that FromArguments parses back: integers in decimal,
+// addresses, hashes and bytes in 0x hex, arrays and tuples as JSON arrays,
+// and tuple types with their component names.
+func ToArguments(args abi.Arguments, event evm.Event) (names []string, types []string, values []string, err error) {
+	for i, arg := range args {
+		name := argumentName(arg, i)
+		v, ok := event[name]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%w: %s", evm.ErrMissingArgument, name)
+		}
+		value, err := formatValue(v, arg.Type)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("argument %s: %w", name, err)
+		}
+		names = append(names, name)
+		types = append(types, TypeString(arg.Type))
+		values = append(values, value)
+	}
+	return names, types, values, nil
+}
+
+// TypeString returns the Solidity type string of t, with the component
+// names of tuples, e.g. "tuple(uint256 id,address owner)[]".
+func TypeString(t abi.Type) string {
+	switch t.T {
+	case abi.TupleTy:
+		components := make([]string, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			components[i] = TypeString(*elem) + " " + t.TupleRawNames[i]
+		}
+		return "tuple(" + strings.Join(components, ",") + ")"
+	case abi.SliceTy:
+		return TypeString(*t.Elem) + "[]"
+	case abi.ArrayTy:
+		return fmt.Sprintf("%s[%d]", TypeString(*t.Elem), t.Size)
+	}
+	return t.String()
+}
+
+func formatValue(v any, t abi.Type) (string, error) {
+	switch t.T {
+	case abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		if _, ok := v.(common.Hash); ok {
+			// The hash of an indexed array or tuple.
+			return formatScalar(v)
+		}
+		raw, err := jsonValue(v, t)
+		return string(raw), err
+	}
+	return formatScalar(v)
+}
+
+// jsonValue formats v as JSON: arrays and tuples as arrays, other values as
+// strings.
+func jsonValue(v any, t abi.Type) (json.RawMessage, error) {
+	var elems []json.RawMessage
+	switch t.T {
+	case abi.TupleTy:
+		tuple, ok := v.(evm.Event)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a tuple", v)
+		}
+		for i, name := range t.TupleRawNames {
+			elem, err := jsonValue(tuple[name], *t.TupleElems[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			elems = append(elems, elem)
+		}
+	case abi.SliceTy, abi.ArrayTy:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, fmt.Errorf("%T is not an array", v)
+		}
+		elems = make([]json.RawMessage, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			elem, err := jsonValue(rv.Index(i).Interface(), *t.Elem)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			elems = append(elems, elem)
+		}
+	default:
+		s, err := formatScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(s)
+	}
+	if elems == nil {
+		elems = []json.RawMessage{}
+	}
+	return json.Marshal(elems)
+}
+
+func formatScalar(v any) (string, error) {
+	switch v := v.(type) {
+	case *big.Int:
+		return v.String(), nil
+	case common.Address:
+		return strings.ToLower(v.Hex()), nil
+	case common.Hash:
+		return v.Hex(), nil
+	case []byte:
+		return hexutil.Encode(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return v, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hexutil.Encode(b), nil
+		}
+	}
+	return "", fmt.Errorf("cannot format %T", v)
+}
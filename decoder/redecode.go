@@ -0,0 +1,269 @@
+package decoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+	"github.com/Zettablock/zsource/destination"
+	"github.com/Zettablock/zsource/utils"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The kinds of rows Redecode decodes.
+const (
+	KindLog         = "log"
+	KindTransaction = "transaction"
+	KindTrace       = "trace"
+)
+
+const defaultBatchSize = 1000
+
+var errNoDestinationDB = errors.New("redecode: writing to a table needs a destination db")
+
+// Decoded is a log, transaction or trace decoded by Redecode. It maps to the
+// table Redecode writes to, declared by DecodedEntity.
+type Decoded struct {
+	Kind            string `gorm:"column:kind;primaryKey" json:"kind"`
+	BlockNumber     int64  `gorm:"column:block_number;primaryKey" json:"block_number"`
+	TransactionHash string `gorm:"column:transaction_hash;primaryKey" json:"transaction_hash"`
+	// Position is the log index of logs, the trace address of traces, e.g.
+	// "0,1", and empty for transactions.
+	Position        string         `gorm:"column:position;primaryKey" json:"position"`
+	ContractAddress string         `gorm:"column:contract_address;not null" json:"contract_address"`
+	Name            string         `gorm:"column:name;not null" json:"name"`
+	Signature       string         `gorm:"column:signature;not null" json:"signature"`
+	ArgumentNames   pq.StringArray `gorm:"column:argument_names;type:text[]" json:"argument_names"`
+	ArgumentTypes   pq.StringArray `gorm:"column:argument_types;type:text[]" json:"argument_types"`
+	ArgumentValues  pq.StringArray `gorm:"column:argument_values;type:text[]" json:"argument_values"`
+	// Arguments are the decoded arguments. They are not written.
+	Arguments evm.Event `gorm:"-" json:"-"`
+}
+
+// DecodedEntity declares the table, called name, that Redecode writes
+// Decoded rows to.
+func DecodedEntity(name string) configs.Entity {
+	return configs.Entity{
+		Name: name,
+		Columns: []configs.Column{
+			{Name: "kind", Type: "text"},
+			{Name: "block_number", Type: "bigint"},
+			{Name: "transaction_hash", Type: "text"},
+			{Name: "position", Type: "text"},
+			{Name: "contract_address", Type: "text"},
+			{Name: "name", Type: "text"},
+			{Name: "signature", Type: "text"},
+			{Name: "argument_names", Type: "text[]", Nullable: true},
+			{Name: "argument_types", Type: "text[]", Nullable: true},
+			{Name: "argument_values", Type: "text[]", Nullable: true},
+		},
+		PrimaryKey:  []string{"kind", "block_number", "transaction_hash", "position"},
+		BlockColumn: "block_number",
+	}
+}
+
+// RedecodeOptions selects the rows Redecode decodes and where they go.
+type RedecodeOptions struct {
+	// From and To are the block range, inclusive.
+	From, To int64
+	// Contracts are the addresses whose logs, and calls to, are decoded; all
+	// contracts when empty. They are lowercased, as the source stores them.
+	Contracts []string
+	// Table, when set, is the destination table the decoded rows are upserted
+	// into instead of being returned. It is created as DecodedEntity if it
+	// does not exist, and is in Destination.Schema, or public if that is not
+	// set, unless qualified.
+	Table string
+	// BatchSize is the number of blocks read at once, 1000 by default.
+	BatchSize int64
+}
+
+// RedecodeResult is the outcome of Redecode.
+type RedecodeResult struct {
+	// Rows are the decoded rows when RedecodeOptions.Table is empty.
+	Rows []Decoded
+	// Written is the number of rows written to RedecodeOptions.Table.
+	Written int
+	// Failed are the rows d has an event or method for but that do not
+	// decode with it. They are skipped.
+	Failed []RowError
+}
+
+// RowError is a row Redecode could not decode, such as a log with more
+// topics than its event has indexed arguments, or calldata too short for the
+// arguments of its method.
+type RowError struct {
+	Kind            string
+	BlockNumber     int64
+	TransactionHash string
+	Position        string
+	Err             error
+}
+
+func (e *RowError) Error() string {
+	at := e.TransactionHash
+	if e.Position != "" {
+		at += "/" + e.Position
+	}
+	return fmt.Sprintf("%s %s of block %d: %v", e.Kind, at, e.BlockNumber, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Redecode decodes with d, typically built from LoadABIs on the plugin abis
+// directory, the logs, transactions and call traces of the source that
+// upstream did not decode, in a block range and for a set of contracts. The
+// rows d has no event or method for are skipped, and so are the rows that do
+// not decode with it, which are reported in RedecodeResult.Failed and logged.
+// The decoded rows are written to opts.Table, or returned when it is empty.
+func Redecode(ctx context.Context, deps *utils.Deps, d *Decoder, opts RedecodeOptions) (*RedecodeResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	contracts := make([]string, 0, len(opts.Contracts))
+	for _, c := range opts.Contracts {
+		contracts = append(contracts, strings.ToLower(c))
+	}
+	table := opts.Table
+	if table != "" {
+		if deps.DestinationDB == nil {
+			return nil, errNoDestinationDB
+		}
+		schema, name, ok := strings.Cut(table, ".")
+		if !ok {
+			schema, name = deps.Config.PipelineConfig.Destination.Schema, table
+			if schema == "" {
+				schema = "public"
+			}
+		}
+		plan := destination.NewPlan(schema, nil, []configs.Entity{DecodedEntity(name)})
+		if err := plan.Apply(deps.DestinationDB.WithContext(ctx), schema); err != nil {
+			return nil, err
+		}
+		table = schema + "." + name
+	}
+
+	schema := deps.Config.GetSourceSchema()
+	result := &RedecodeResult{}
+	for from := opts.From; from <= opts.To; from += opts.BatchSize {
+		to := min(from+opts.BatchSize-1, opts.To)
+		undecoded := func(name string, addressColumn string) *gorm.DB {
+			q := deps.SourceDB.WithContext(ctx).Table(schema+"."+name).
+				Where("block_number BETWEEN ? AND ? AND decoded_from_abi IS NOT TRUE", from, to)
+			if len(contracts) > 0 {
+				q = q.Where(addressColumn+" IN ?", contracts)
+			}
+			return q
+		}
+
+		var logs []*ethereum.Log
+		if err := undecoded("logs", "contract_address").Find(&logs).Error; err != nil {
+			return nil, err
+		}
+		var txs []*ethereum.Transaction
+		if err := undecoded("transactions", "to_address").Where("length(input) > 2").Find(&txs).Error; err != nil {
+			return nil, err
+		}
+		var traces []*ethereum.Trace
+		if err := undecoded("traces", "to_address").Where("trace_type = 'call' AND length(input) > 2").Find(&traces).Error; err != nil {
+			return nil, err
+		}
+
+		batch, failed := d.decodeRows(logs, txs, traces)
+		result.Failed = append(result.Failed, failed...)
+		if deps.Logger != nil {
+			for _, f := range failed {
+				deps.Logger.Warn("skipped row that does not decode", "error", f.Error())
+			}
+			deps.Logger.Debug("redecoded blocks", "from", from, "to", to, "rows", len(batch), "failed", len(failed))
+		}
+		if table == "" {
+			result.Rows = append(result.Rows, batch...)
+			continue
+		}
+		if len(batch) > 0 {
+			err := deps.DestinationDB.WithContext(ctx).Table(table).
+				Clauses(clause.OnConflict{UpdateAll: true}).
+				CreateInBatches(batch, 500).Error
+			if err != nil {
+				return nil, err
+			}
+			result.Written += len(batch)
+		}
+	}
+	return result, nil
+}
+
+// decodeRows decodes the rows d knows the event or method of, and returns
+// those that do not decode with it as failed.
+func (d *Decoder) decodeRows(logs []*ethereum.Log, txs []*ethereum.Transaction, traces []*ethereum.Trace) ([]Decoded, []RowError) {
+	var rows []Decoded
+	var failed []RowError
+	add := func(row Decoded, err error) {
+		if err != nil {
+			failed = append(failed, RowError{Kind: row.Kind, BlockNumber: row.BlockNumber, TransactionHash: row.TransactionHash, Position: row.Position, Err: err})
+			return
+		}
+		rows = append(rows, row)
+	}
+	for _, log := range logs {
+		row := Decoded{Kind: KindLog, BlockNumber: log.BlockNumber, TransactionHash: log.TransactionHash, Position: strconv.Itoa(int(log.LogIndex))}
+		event, values, err := d.DecodeLog(log)
+		if errors.Is(err, ErrUnknownEvent) {
+			continue
+		}
+		if err == nil {
+			err = row.decoded(event.RawName, event.Sig, event.Inputs, values)
+		}
+		row.ContractAddress = strings.ToLower(log.ContractAddress)
+		add(row, err)
+	}
+	for _, tx := range txs {
+		row := Decoded{Kind: KindTransaction, BlockNumber: tx.BlockNumber, TransactionHash: tx.Hash}
+		method, values, err := d.DecodeInput(tx.Input)
+		if errors.Is(err, ErrUnknownMethod) {
+			continue
+		}
+		if err == nil {
+			err = row.decoded(method.RawName, method.Sig, method.Inputs, values)
+		}
+		row.ContractAddress = strings.ToLower(tx.ToAddress)
+		add(row, err)
+	}
+	for _, trace := range traces {
+		row := Decoded{Kind: KindTrace, BlockNumber: trace.BlockNumber, TransactionHash: trace.TransactionHash, Position: strings.Join(trace.TraceAddress, ",")}
+		method, values, err := d.DecodeInput(trace.Input)
+		if errors.Is(err, ErrUnknownMethod) {
+			continue
+		}
+		if err == nil {
+			err = row.decoded(method.RawName, method.Sig, method.Inputs, values)
+		}
+		row.ContractAddress = strings.ToLower(trace.ToAddress)
+		add(row, err)
+	}
+	return rows, failed
+}
+
+// decoded sets the decoded columns of row.
+func (row *Decoded) decoded(name string, signature string, args abi.Arguments, values evm.Event) error {
+	names, types, formatted, err := ToArguments(args, values)
+	if err != nil {
+		return err
+	}
+	row.Name, row.Signature = name, signature
+	row.ArgumentNames, row.ArgumentTypes, row.ArgumentValues = names, types, formatted
+	row.Arguments = values
+	return nil
+}
@@ -0,0 +1,258 @@
+package decoder
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+	"github.com/Zettablock/zsource/destination"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+	"github.com/Zettablock/zsource/utils"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lib/pq"
+)
+
+func TestLoadABIs(t *testing.T) {
+	dir := writeABIs(t, map[string]string{
+		"Token.json": testABI,
+		"NFT.json":   testNFTABI,
+		"notes.txt":  "not an abi",
+	})
+	merged, err := LoadABIs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := merged.Methods["transfer"]; !ok {
+		t.Error("merged abi has no transfer method")
+	}
+	if _, ok := merged.Events["Transfer"]; !ok {
+		t.Error("merged abi has no Transfer event")
+	}
+
+	only, err := LoadABIs(dir, "Token.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(only.Events) != 3 || len(only.Methods) != 2 || len(only.Errors) != 1 {
+		t.Errorf("LoadABIs(Token.json) has %d events, %d methods and %d errors, want 3, 2 and 1", len(only.Events), len(only.Methods), len(only.Errors))
+	}
+	if _, err := LoadABIs(t.TempDir()); err == nil {
+		t.Error("LoadABIs() of an empty directory succeeded")
+	}
+}
+
+func TestToArguments(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	event := contractAbi.Events["Listed"]
+	items := []struct {
+		Id    *big.Int
+		Owner common.Address
+	}{{big.NewInt(1), alice}, {big.NewInt(2), bob}}
+	log := &ethereum.Log{
+		Topics: []string{event.ID.Hex(), common.Hash{1}.Hex()},
+		Data:   pack(t, event, items, []byte{0xca, 0xfe}),
+	}
+	_, values, err := d.DecodeLog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, types, formatted, err := ToArguments(event.Inputs, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []string{"string", "tuple(uint256 id,address owner)[]", "bytes"}
+	if !reflect.DeepEqual(names, []string{"name", "items", "arg2"}) || !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("ToArguments() = %v %v, want [name items arg2] %v", names, types, wantTypes)
+	}
+	parsed, err := FromArguments(names, types, formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed["items"], values["items"]) || !reflect.DeepEqual(parsed["arg2"], values["arg2"]) {
+		t.Errorf("FromArguments(ToArguments()) = %v, want %v", parsed, values)
+	}
+}
+
+func TestLoadABIsSharedEventID(t *testing.T) {
+	dir := writeABIs(t, map[string]string{"Token.json": testABI, "NFT.json": testNFTABI})
+	merged, err := LoadABIs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(merged)
+	transfer := merged.Events["Transfer"]
+	// ERC-721 transfers have the ID of ERC-20 ones and one more topic.
+	log := &ethereum.Log{
+		Topics: []string{transfer.ID.Hex(), common.BytesToHash(alice.Bytes()).Hex(), common.BytesToHash(bob.Bytes()).Hex(), common.BigToHash(big.NewInt(42)).Hex()},
+		Data:   "0x",
+	}
+	event, values, err := d.DecodeLog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (evm.Event{"from": alice, "to": bob, "tokenId": big.NewInt(42)}); event.RawName != "Transfer" || !reflect.DeepEqual(values, want) {
+		t.Errorf("DecodeLog() = %s %v, want Transfer %v", event.RawName, values, want)
+	}
+}
+
+func TestDecodeRows(t *testing.T) {
+	d, merged := testDecoder(t)
+	transfer := merged.Events["Transfer"]
+	logs := []*ethereum.Log{{
+		BlockNumber:     10,
+		TransactionHash: "0xaa",
+		LogIndex:        3,
+		ContractAddress: "0xC0FFEE",
+		Topics:          []string{transfer.ID.Hex(), common.BytesToHash(alice.Bytes()).Hex(), common.BytesToHash(bob.Bytes()).Hex()},
+		Data:            pack(t, transfer, big.NewInt(5)),
+	}, {
+		BlockNumber: 10,
+		Topics:      []string{common.Hash{9}.Hex()},
+	}, {
+		// An ERC-721 transfer, which the ABI has no event for.
+		BlockNumber:     10,
+		TransactionHash: "0xaa",
+		LogIndex:        4,
+		Topics:          []string{transfer.ID.Hex(), common.BytesToHash(alice.Bytes()).Hex(), common.BytesToHash(bob.Bytes()).Hex(), common.BigToHash(big.NewInt(42)).Hex()},
+		Data:            "0x",
+	}}
+	input := callInput(t, merged, "transfer", bob, big.NewInt(5))
+	txs := []*ethereum.Transaction{
+		{BlockNumber: 10, Hash: "0xaa", ToAddress: "0xc0ffee", Input: input},
+		{BlockNumber: 10, Hash: "0xbb", ToAddress: "0xc0ffee", Input: input[:30]},
+		{BlockNumber: 10, Hash: "0xcc", ToAddress: "0xc0ffee", Input: "0x12345678"},
+	}
+	traces := []*ethereum.Trace{{BlockNumber: 10, TransactionHash: "0xaa", ToAddress: "0xc0ffee", TraceAddress: []string{"0", "1"}, Input: input}}
+
+	rows, failed := d.decodeRows(logs, txs, traces)
+	if len(rows) != 3 {
+		t.Fatalf("decodeRows() = %d rows, want 3", len(rows))
+	}
+	want := []Decoded{
+		{Kind: KindLog, Position: "3", ContractAddress: "0xc0ffee", Name: "Transfer", Signature: "Transfer(address,address,uint256)"},
+		{Kind: KindTransaction, Position: "", ContractAddress: "0xc0ffee", Name: "transfer", Signature: "transfer(address,uint256)"},
+		{Kind: KindTrace, Position: "0,1", ContractAddress: "0xc0ffee", Name: "transfer", Signature: "transfer(address,uint256)"},
+	}
+	for i, row := range rows {
+		got := Decoded{Kind: row.Kind, Position: row.Position, ContractAddress: row.ContractAddress, Name: row.Name, Signature: row.Signature}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("row %d = %+v, want %+v", i, got, want[i])
+		}
+		if row.BlockNumber != 10 || row.TransactionHash != "0xaa" {
+			t.Errorf("row %d is at %d %s, want 10 0xaa", i, row.BlockNumber, row.TransactionHash)
+		}
+	}
+	if got := []string(rows[0].ArgumentValues); !reflect.DeepEqual(got, []string{strings.ToLower(alice.Hex()), strings.ToLower(bob.Hex()), "5"}) {
+		t.Errorf("log argument values = %v", got)
+	}
+
+	// The mismatched log and the truncated calldata fail; the unknown
+	// selector is skipped.
+	if len(failed) != 2 {
+		t.Fatalf("decodeRows() failed %v, want 2 rows", failed)
+	}
+	if f := failed[0]; f.Kind != KindLog || f.TransactionHash != "0xaa" || f.Position != "4" {
+		t.Errorf("failed[0] = %v, want log 0xaa/4", &f)
+	}
+	if f := failed[1]; f.Kind != KindTransaction || f.TransactionHash != "0xbb" || errors.Is(f.Err, ErrUnknownMethod) {
+		t.Errorf("failed[1] = %v, want transaction 0xbb", &f)
+	}
+}
+
+func TestRedecode(t *testing.T) {
+	d, merged := testDecoder(t)
+	transfer := merged.Events["Transfer"]
+	source, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := []string{transfer.ID.Hex(), common.BytesToHash(alice.Bytes()).Hex(), common.BytesToHash(bob.Bytes()).Hex()}
+	source.Rows(`"ethereum"."logs"`, []string{"block_number", "transaction_hash", "log_index", "contract_address", "topics", "data"},
+		[]any{3, "0xaa", 0, "0xc0ffee", pq.StringArray(topics), pack(t, transfer, big.NewInt(5))},
+		[]any{3, "0xaa", 1, "0xc0ffee", pq.StringArray(append(topics, topics[1])), "0x"},
+	)
+	input := callInput(t, merged, "transfer", bob, big.NewInt(5))
+	source.Rows(`"ethereum"."transactions"`, []string{"block_number", "hash", "to_address", "input"},
+		[]any{3, "0xaa", "0xc0ffee", input},
+	)
+
+	cfg := &configs.Config{PipelineConfig: configs.PipelineConfig{Source: configs.Source{Schema: "ethereum"}}}
+	deps := &utils.Deps{SourceDB: source.DB, Config: cfg}
+	result, err := Redecode(context.Background(), deps, d, RedecodeOptions{From: 1, To: 7, BatchSize: 5, Contracts: []string{"0xC0FFEE"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both batches read the same rows from the fake source.
+	if len(result.Rows) != 4 || len(result.Failed) != 2 {
+		t.Fatalf("Redecode() = %d rows and %d failed, want 4 and 2", len(result.Rows), len(result.Failed))
+	}
+	if row := result.Rows[0]; row.Kind != KindLog || row.Name != "Transfer" || row.BlockNumber != 3 {
+		t.Errorf("Redecode() row 0 = %+v", row)
+	}
+	if row := result.Rows[1]; row.Kind != KindTransaction || row.Name != "transfer" {
+		t.Errorf("Redecode() row 1 = %+v", row)
+	}
+
+	queries := source.Statements(`"ethereum"."logs"`)
+	if len(queries) != 2 {
+		t.Fatalf("Redecode() ran %d logs queries, want 2", len(queries))
+	}
+	if q := queries[1]; !strings.Contains(q.SQL, "decoded_from_abi IS NOT TRUE") || !strings.Contains(q.SQL, " contract_address IN ($3)") || !reflect.DeepEqual(q.Args, []any{int64(6), int64(7), "0xc0ffee"}) {
+		t.Errorf("second logs query = %s %v, want blocks 6 to 7 of 0xc0ffee", q.SQL, q.Args)
+	}
+	if n := len(source.Statements(`"ethereum"."traces"`)); n != 2 {
+		t.Errorf("Redecode() ran %d traces queries, want 2", n)
+	}
+}
+
+func TestRedecodeTable(t *testing.T) {
+	d, merged := testDecoder(t)
+	transfer := merged.Events["Transfer"]
+	source, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := []string{transfer.ID.Hex(), common.BytesToHash(alice.Bytes()).Hex(), common.BytesToHash(bob.Bytes()).Hex()}
+	source.Rows(`"ethereum"."logs"`, []string{"block_number", "transaction_hash", "log_index", "contract_address", "topics", "data"},
+		[]any{3, "0xaa", 0, "0xc0ffee", pq.StringArray(topics), pack(t, transfer, big.NewInt(5))},
+	)
+	cfg := &configs.Config{PipelineConfig: configs.PipelineConfig{
+		Source:      configs.Source{Schema: "ethereum"},
+		Destination: configs.Destination{Schema: "dest"},
+	}}
+	opts := RedecodeOptions{From: 1, To: 5, Table: "decoded"}
+
+	deps := &utils.Deps{SourceDB: source.DB, Config: cfg}
+	if _, err := Redecode(context.Background(), deps, d, opts); !errors.Is(err, errNoDestinationDB) {
+		t.Errorf("Redecode() without a destination db = %v, want errNoDestinationDB", err)
+	}
+
+	dest, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps.DestinationDB = dest.DB
+	result, err := Redecode(context.Background(), deps, d, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Written != 1 || len(result.Rows) != 0 {
+		t.Errorf("Redecode() = %d written and %d rows, want 1 and 0", result.Written, len(result.Rows))
+	}
+	// The table is created by the plan of its entity.
+	want := destination.NewPlan("dest", nil, []configs.Entity{DecodedEntity("decoded")}).Statements[0].SQL
+	if created := dest.Statements("CREATE TABLE"); len(created) != 1 || created[0].SQL != want {
+		t.Errorf("Redecode() created %v, want %s", created, want)
+	}
+	written := dest.Statements(`INSERT INTO "dest"."decoded"`)
+	if len(written) != 1 || !strings.Contains(written[0].SQL, "ON CONFLICT") {
+		t.Errorf("Redecode() wrote %v, want an upsert into dest.decoded", written)
+	}
+}
@@ -0,0 +1,116 @@
+package decoder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	ErrUnknownError = errors.New("no abi error matches the selector")
+	ErrNotReverted  = errors.New("call did not revert")
+)
+
+// The errors the Solidity compiler reverts with: Error(string) for require
+// and revert with a message, and Panic(uint256) for failed asserts,
+// arithmetic overflows and the like.
+var (
+	errorError = abi.NewError("Error", abi.Arguments{{Name: "message", Type: mustType("string")}})
+	panicError = abi.NewError("Panic", abi.Arguments{{Name: "code", Type: mustType("uint256")}})
+)
+
+// Revert is the decoded reason a call reverted with.
+type Revert struct {
+	// Name is "Error", "Panic" or the name of the custom error, and empty
+	// when the call reverted without data.
+	Name      string
+	Signature string
+	Arguments evm.Event
+	// Reason describes the revert: the message of Error, the cause of Panic,
+	// e.g. "arithmetic underflow or overflow", or the custom error with its
+	// arguments, e.g. "InsufficientBalance(10,20)".
+	Reason string
+}
+
+// DecodeRevert decodes the data a call reverted with into the error it
+// carries: Error(string), Panic(uint256) or a custom error of the ABI,
+// identified by its 4-byte selector. Data that is empty decodes into an
+// empty Revert. d may be nil, in which case only Error and Panic decode.
+func (d *Decoder) DecodeRevert(data string) (*Revert, error) {
+	b, err := decodeHex(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return &Revert{}, nil
+	}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: revert data %q has no selector", ErrMalformed, data)
+	}
+	var e *abi.Error
+	switch selector := [4]byte(b[:4]); {
+	case selector == [4]byte(errorError.ID[:4]):
+		e = &errorError
+	case selector == [4]byte(panicError.ID[:4]):
+		e = &panicError
+	case d != nil:
+		if e, err = d.abi.ErrorByID(selector); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownError, hexutil.Encode(b[:4]))
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownError, hexutil.Encode(b[:4]))
+	}
+	values, err := unpackArguments(e.Inputs, b[4:])
+	if err != nil {
+		return nil, fmt.Errorf("error %s: %w", e.Sig, err)
+	}
+	revert := &Revert{Name: e.Name, Signature: e.Sig, Arguments: values}
+	if e == &errorError || e == &panicError {
+		if revert.Reason, err = abi.UnpackRevert(b); err != nil {
+			return nil, err
+		}
+		return revert, nil
+	}
+	_, _, formatted, err := ToArguments(e.Inputs, values)
+	if err != nil {
+		return nil, err
+	}
+	revert.Reason = e.Name + "(" + strings.Join(formatted, ",") + ")"
+	return revert, nil
+}
+
+// TraceRevert decodes the reason a failed trace reverted with from its
+// output, or from its error when the node reported the revert data there.
+// When neither holds revert data the Revert only has the error as Reason,
+// e.g. "Reverted" or "out of gas". Successful traces return ErrNotReverted.
+func (d *Decoder) TraceRevert(trace *ethereum.Trace) (*Revert, error) {
+	if trace.Status == 1 && trace.Error == "" {
+		return nil, ErrNotReverted
+	}
+	data := trace.Output
+	if len(data) <= 2 && strings.HasPrefix(trace.Error, "0x") {
+		data = trace.Error
+	}
+	revert, err := d.DecodeRevert(data)
+	if err != nil {
+		return nil, err
+	}
+	if revert.Reason == "" && !strings.HasPrefix(trace.Error, "0x") {
+		revert.Reason = trace.Error
+	}
+	return revert, nil
+}
+
+func mustType(t string) abi.Type {
+	typ, err := abi.NewType(t, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
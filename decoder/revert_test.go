@@ -0,0 +1,90 @@
+package decoder
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+)
+
+func TestDecodeRevert(t *testing.T) {
+	d, contractAbi := testDecoder(t)
+	insufficient := contractAbi.Errors["InsufficientBalance"]
+	tests := []struct {
+		name string
+		data string
+		want Revert
+	}{
+		{
+			"error",
+			revertData(t, errorError.Inputs, errorError.ID[:4], "not owner"),
+			Revert{Name: "Error", Signature: "Error(string)", Arguments: evm.Event{"message": "not owner"}, Reason: "not owner"},
+		},
+		{
+			"panic",
+			revertData(t, panicError.Inputs, panicError.ID[:4], big.NewInt(0x11)),
+			Revert{Name: "Panic", Signature: "Panic(uint256)", Arguments: evm.Event{"code": big.NewInt(0x11)}, Reason: "arithmetic underflow or overflow"},
+		},
+		{
+			"custom error",
+			revertData(t, insufficient.Inputs, insufficient.ID[:4], big.NewInt(10), big.NewInt(20)),
+			Revert{
+				Name:      "InsufficientBalance",
+				Signature: "InsufficientBalance(uint256,uint256)",
+				Arguments: evm.Event{"available": big.NewInt(10), "required": big.NewInt(20)},
+				Reason:    "InsufficientBalance(10,20)",
+			},
+		},
+		{"no data", "0x", Revert{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.DecodeRevert(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("DecodeRevert() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	custom := revertData(t, insufficient.Inputs, insufficient.ID[:4], big.NewInt(10), big.NewInt(20))
+	if _, err := (*Decoder)(nil).DecodeRevert(custom); !errors.Is(err, ErrUnknownError) {
+		t.Errorf("DecodeRevert() without abi error = %v, want ErrUnknownError", err)
+	}
+	if _, err := d.DecodeRevert("0x1234"); !errors.Is(err, ErrMalformed) {
+		t.Errorf("DecodeRevert(0x1234) error = %v, want ErrMalformed", err)
+	}
+}
+
+func TestTraceRevert(t *testing.T) {
+	d, _ := testDecoder(t)
+	message := revertData(t, errorError.Inputs, errorError.ID[:4], "paused")
+	tests := []struct {
+		name  string
+		trace *ethereum.Trace
+		want  string
+	}{
+		{"output", &ethereum.Trace{Output: message, Error: "Reverted"}, "paused"},
+		{"error", &ethereum.Trace{Error: message}, "paused"},
+		{"no data", &ethereum.Trace{Error: "out of gas"}, "out of gas"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.TraceRevert(tt.trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Reason != tt.want {
+				t.Errorf("TraceRevert() reason = %q, want %q", got.Reason, tt.want)
+			}
+		})
+	}
+	if _, err := d.TraceRevert(&ethereum.Trace{Status: 1}); !errors.Is(err, ErrNotReverted) {
+		t.Errorf("TraceRevert() of a successful trace = %v, want ErrNotReverted", err)
+	}
+}
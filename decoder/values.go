@@ -0,0 +1,52 @@
+package decoder
+
+import (
+	"reflect"
+
+	"github.com/Zettablock/zsource/dao/evm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// convert turns the tuples go-ethereum unpacks into anonymous structs into
+// evm.Event values keyed by component name, at any depth. Other values are
+// returned as is.
+func convert(v any, t abi.Type) any {
+	switch t.T {
+	case abi.TupleTy:
+		rv := reflect.Indirect(reflect.ValueOf(v))
+		tuple := make(evm.Event, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			tuple[t.TupleRawNames[i]] = convert(rv.Field(i).Interface(), *elem)
+		}
+		return tuple
+	case abi.SliceTy, abi.ArrayTy:
+		if !hasTuple(*t.Elem) {
+			return v
+		}
+		rv := reflect.ValueOf(v)
+		if t.Elem.T == abi.TupleTy {
+			tuples := make([]evm.Event, rv.Len())
+			for i := range tuples {
+				tuples[i] = convert(rv.Index(i).Interface(), *t.Elem).(evm.Event)
+			}
+			return tuples
+		}
+		values := make([]any, rv.Len())
+		for i := range values {
+			values[i] = convert(rv.Index(i).Interface(), *t.Elem)
+		}
+		return values
+	}
+	return v
+}
+
+func hasTuple(t abi.Type) bool {
+	switch t.T {
+	case abi.TupleTy:
+		return true
+	case abi.SliceTy, abi.ArrayTy:
+		return hasTuple(*t.Elem)
+	}
+	return false
+}
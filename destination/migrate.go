@@ -0,0 +1,349 @@
+package destination
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/metadata"
+	"github.com/Zettablock/zsource/utils"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+var ErrDestructiveMigration = errors.New("destructive migration not allowed")
+
+// ErrInvalidMigration is returned for plans that cannot apply to tables with
+// rows, such as adding a NOT NULL column without a default.
+var ErrInvalidMigration = errors.New("invalid migration")
+
+// Statement is one DDL statement of a migration plan.
+type Statement struct {
+	SQL         string
+	Description string
+	// Destructive is set for statements that may lose data: dropping tables
+	// or columns, changing column types, nullability or primary keys.
+	Destructive bool
+	// Invalid is set for statements that fail on tables with rows.
+	Invalid bool
+}
+
+// Plan is the list of statements that brings the destination schema from one
+// version of the entity declarations to the next.
+type Plan struct {
+	Statements []Statement
+}
+
+// Empty reports whether the plan has nothing to do.
+func (p *Plan) Empty() bool {
+	return len(p.Statements) == 0
+}
+
+// Destructive returns the destructive statements of the plan.
+func (p *Plan) Destructive() []Statement {
+	var out []Statement
+	for _, s := range p.Statements {
+		if s.Destructive {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Invalid returns the invalid statements of the plan.
+func (p *Plan) Invalid() []Statement {
+	var out []Statement
+	for _, s := range p.Statements {
+		if s.Invalid {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Apply runs the statements of the plan on db in one transaction, after
+// creating schema if it does not exist.
+func (p *Plan) Apply(db *gorm.DB, schema string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pq.QuoteIdentifier(schema))).Error; err != nil {
+			return err
+		}
+		for _, s := range p.Statements {
+			if err := tx.Exec(s.SQL).Error; err != nil {
+				return fmt.Errorf("%s: %w", s.Description, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Plan) add(destructive bool, description string, format string, args ...any) {
+	p.Statements = append(p.Statements, Statement{
+		SQL:         fmt.Sprintf(format, args...),
+		Description: description,
+		Destructive: destructive,
+	})
+}
+
+// NewPlan diffs the entities of the previous version, nil for the first one,
+// against the current ones. Statements are idempotent (IF [NOT] EXISTS) so
+// that a plan can be applied again after a partial failure.
+func NewPlan(schema string, previous []configs.Entity, current []configs.Entity) *Plan {
+	previous, current = expand(previous), expand(current)
+	p := &Plan{}
+	old := make(map[string]*configs.Entity, len(previous))
+	for i := range previous {
+		old[previous[i].Name] = &previous[i]
+	}
+	seen := make(map[string]bool, len(current))
+
+	for i := range current {
+		e := &current[i]
+		seen[e.Name] = true
+		table := qualify(schema, e.Name)
+		prev, ok := old[e.Name]
+		if !ok {
+			p.add(false, "create table "+e.Name, "CREATE TABLE IF NOT EXISTS %s (%s)", table, tableBody(e))
+			for _, index := range e.Indexes {
+				p.add(false, "create index "+index.IndexName(e.Name), "%s", createIndex(schema, e.Name, index))
+			}
+			continue
+		}
+		diffColumns(p, table, prev, e)
+		if strings.Join(prev.PrimaryKey, ",") != strings.Join(e.PrimaryKey, ",") {
+			constraint := pq.QuoteIdentifier(e.Name + "_pkey")
+			p.add(true, "change primary key of "+e.Name, "ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s, ADD CONSTRAINT %s PRIMARY KEY (%s)",
+				table, constraint, constraint, quoteList(e.PrimaryKey))
+		}
+		diffIndexes(p, schema, prev, e)
+	}
+
+	for i := range previous {
+		if !seen[previous[i].Name] {
+			p.add(true, "drop table "+previous[i].Name, "DROP TABLE IF EXISTS %s", qualify(schema, previous[i].Name))
+		}
+	}
+	return p
+}
+
+// expand returns a copy of entities with their provenance columns.
+func expand(entities []configs.Entity) []configs.Entity {
+	expanded := make([]configs.Entity, 0, len(entities))
+	for _, e := range entities {
+		expanded = append(expanded, e.Expand())
+	}
+	return expanded
+}
+
+func diffColumns(p *Plan, table string, prev *configs.Entity, e *configs.Entity) {
+	for _, c := range e.Columns {
+		old := prev.Column(c.Name)
+		if old == nil {
+			p.add(false, "add column "+c.Name, "ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", table, columnDef(c))
+			if !c.Nullable && c.Default == "" {
+				// The existing rows would have no value for the column.
+				s := &p.Statements[len(p.Statements)-1]
+				s.Description = "add not null column " + c.Name + " without a default"
+				s.Invalid = true
+			}
+			continue
+		}
+		column := pq.QuoteIdentifier(c.Name)
+		if !strings.EqualFold(old.Type, c.Type) {
+			p.add(true, fmt.Sprintf("change type of %s from %s to %s", c.Name, old.Type, c.Type),
+				"ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", table, column, c.Type, column, c.Type)
+		}
+		switch {
+		case old.Nullable && !c.Nullable:
+			p.add(true, "make "+c.Name+" not null", "ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, column)
+		case !old.Nullable && c.Nullable:
+			p.add(false, "make "+c.Name+" nullable", "ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", table, column)
+		}
+		if old.Default != c.Default {
+			if c.Default == "" {
+				p.add(false, "drop default of "+c.Name, "ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", table, column)
+			} else {
+				p.add(false, "set default of "+c.Name, "ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", table, column, c.Default)
+			}
+		}
+	}
+	for _, c := range prev.Columns {
+		if e.Column(c.Name) == nil {
+			p.add(true, "drop column "+c.Name, "ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, pq.QuoteIdentifier(c.Name))
+		}
+	}
+}
+
+func diffIndexes(p *Plan, schema string, prev *configs.Entity, e *configs.Entity) {
+	old := make(map[string]configs.Index, len(prev.Indexes))
+	for _, index := range prev.Indexes {
+		old[index.IndexName(prev.Name)] = index
+	}
+	current := make(map[string]bool, len(e.Indexes))
+	for _, index := range e.Indexes {
+		name := index.IndexName(e.Name)
+		current[name] = true
+		if o, ok := old[name]; ok && o.Unique == index.Unique && strings.Join(o.Columns, ",") == strings.Join(index.Columns, ",") {
+			continue
+		} else if ok {
+			p.add(false, "drop changed index "+name, "DROP INDEX IF EXISTS %s", qualify(schema, name))
+		}
+		p.add(false, "create index "+name, "%s", createIndex(schema, e.Name, index))
+	}
+	for name := range old {
+		if !current[name] {
+			p.add(false, "drop index "+name, "DROP INDEX IF EXISTS %s", qualify(schema, name))
+		}
+	}
+}
+
+func tableBody(e *configs.Entity) string {
+	defs := make([]string, 0, len(e.Columns)+1)
+	for _, c := range e.Columns {
+		defs = append(defs, columnDef(c))
+	}
+	defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteList(e.PrimaryKey)))
+	return strings.Join(defs, ", ")
+}
+
+func columnDef(c configs.Column) string {
+	def := pq.QuoteIdentifier(c.Name) + " " + c.Type
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	if c.Default != "" {
+		def += " DEFAULT " + c.Default
+	}
+	return def
+}
+
+func createIndex(schema string, entity string, index configs.Index) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)",
+		unique, pq.QuoteIdentifier(index.IndexName(entity)), qualify(schema, entity), quoteList(index.Columns))
+}
+
+func qualify(schema string, name string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name)
+}
+
+func quoteList(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, pq.QuoteIdentifier(name))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Migrate brings the destination schema in line with the entities declared in
+// the pipeline config. The applied declarations are versioned in the
+// schema_versions metadata table with the schema they were applied to; a new
+// version is recorded whenever either changes, and the plan is diffed against
+// the last version applied to the same schema. Destructive plans are refused
+// with ErrDestructiveMigration unless Destination.AllowDestructiveMigrations
+// is set, and invalid ones with ErrInvalidMigration. The returned plan is what
+// was applied, or what was refused.
+//
+// When the destination db is the metadata db, the plan is applied and its
+// version recorded in one transaction. Otherwise the version is recorded as
+// pending before the plan is applied and marked applied after, so that a
+// failure in between leaves a pending version rather than the previous one
+// as the last applied; the next run then applies the plan again, which its
+// statements allow, and replaces the pending version.
+func Migrate(deps *utils.Deps) (*Plan, error) {
+	cfg := deps.Config
+	dest := cfg.PipelineConfig.Destination
+
+	var number int64
+	err := deps.MetadataDB.Model(&metadata.SchemaVersion{}).
+		Select("COALESCE(MAX(version), 0)").
+		Where("project = ? AND pipeline = ? AND NOT pending", cfg.GetProjectName(), cfg.GetPipelineName()).
+		Scan(&number).Error
+	if err != nil {
+		return nil, err
+	}
+	var latest metadata.SchemaVersion
+	err = deps.MetadataDB.
+		Where("project = ? AND pipeline = ? AND schema = ? AND NOT pending", cfg.GetProjectName(), cfg.GetPipelineName(), dest.Schema).
+		Order("version DESC").
+		Limit(1).
+		Find(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+	var previous []configs.Entity
+	if latest.Version > 0 {
+		if err := json.Unmarshal([]byte(latest.Definition), &previous); err != nil {
+			return nil, fmt.Errorf("schema version %d: %w", latest.Version, err)
+		}
+	}
+
+	definition, err := json.Marshal(dest.Entities)
+	if err != nil {
+		return nil, err
+	}
+	if latest.Version > 0 && latest.Version == number && latest.Definition == string(definition) {
+		return &Plan{}, nil
+	}
+
+	plan := NewPlan(dest.Schema, previous, dest.Entities)
+	if invalid := plan.Invalid(); len(invalid) > 0 {
+		descriptions := make([]string, 0, len(invalid))
+		for _, s := range invalid {
+			descriptions = append(descriptions, s.Description)
+		}
+		return plan, fmt.Errorf("%w: %s; make the columns nullable or give them a default", ErrInvalidMigration, strings.Join(descriptions, ", "))
+	}
+	if destructive := plan.Destructive(); len(destructive) > 0 && !dest.AllowDestructiveMigrations {
+		descriptions := make([]string, 0, len(destructive))
+		for _, s := range destructive {
+			descriptions = append(descriptions, s.Description)
+		}
+		return plan, fmt.Errorf("%w: %s; set allowDestructiveMigrations to apply", ErrDestructiveMigration, strings.Join(descriptions, ", "))
+	}
+
+	version := metadata.SchemaVersion{
+		Project:    cfg.GetProjectName(),
+		Pipeline:   cfg.GetPipelineName(),
+		Version:    number + 1,
+		Schema:     dest.Schema,
+		Definition: string(definition),
+		AppliedAt:  time.Now().UTC(),
+	}
+	if deps.MetadataDB == deps.DestinationDB {
+		err := deps.Transaction(func(tx *utils.Deps) error {
+			if err := plan.Apply(tx.DestinationDB, dest.Schema); err != nil {
+				return err
+			}
+			return tx.MetadataDB.Create(&version).Error
+		})
+		return plan, err
+	}
+
+	version.Pending = true
+	err = deps.MetadataDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("project = ? AND pipeline = ? AND pending", version.Project, version.Pipeline).
+			Delete(&metadata.SchemaVersion{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&version).Error
+	})
+	if err != nil {
+		return plan, err
+	}
+	if err := plan.Apply(deps.DestinationDB, dest.Schema); err != nil {
+		return plan, err
+	}
+	if err := deps.MetadataDB.Model(&version).Update("pending", false).Error; err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
@@ -0,0 +1,282 @@
+package destination
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+	"github.com/Zettablock/zsource/utils"
+)
+
+func transfers() configs.Entity {
+	return configs.Entity{
+		Name: "transfers",
+		Columns: []configs.Column{
+			{Name: "id", Type: "text"},
+			{Name: "amount", Type: "numeric(78,0)"},
+			{Name: "memo", Type: "text", Nullable: true},
+		},
+		PrimaryKey: []string{"id"},
+		Indexes:    []configs.Index{{Columns: []string{"amount"}}},
+	}
+}
+
+func TestNewPlanCreate(t *testing.T) {
+	plan := NewPlan("dest", nil, []configs.Entity{transfers()})
+	if len(plan.Statements) != 2 {
+		t.Fatalf("statements = %d, want 2", len(plan.Statements))
+	}
+	want := `CREATE TABLE IF NOT EXISTS "dest"."transfers" ("id" text NOT NULL, "amount" numeric(78,0) NOT NULL, "memo" text, PRIMARY KEY ("id"))`
+	if plan.Statements[0].SQL != want {
+		t.Errorf("create table = %s, want %s", plan.Statements[0].SQL, want)
+	}
+	want = `CREATE INDEX IF NOT EXISTS "transfers_amount_idx" ON "dest"."transfers" ("amount")`
+	if plan.Statements[1].SQL != want {
+		t.Errorf("create index = %s, want %s", plan.Statements[1].SQL, want)
+	}
+	if len(plan.Destructive()) != 0 {
+		t.Errorf("create plan should not be destructive")
+	}
+}
+
+func TestNewPlanUnchanged(t *testing.T) {
+	plan := NewPlan("dest", []configs.Entity{transfers()}, []configs.Entity{transfers()})
+	if !plan.Empty() {
+		t.Errorf("plan = %v, want empty", plan.Statements)
+	}
+}
+
+func TestNewPlanAdditive(t *testing.T) {
+	next := transfers()
+	next.Columns = append(next.Columns, configs.Column{Name: "sender", Type: "text", Nullable: true})
+	next.Columns[1].Default = "0"
+	next.Indexes = append(next.Indexes, configs.Index{Columns: []string{"sender"}})
+
+	plan := NewPlan("dest", []configs.Entity{transfers()}, []configs.Entity{next})
+	if len(plan.Destructive()) != 0 {
+		t.Errorf("destructive = %v, want none", plan.Destructive())
+	}
+	var sql []string
+	for _, s := range plan.Statements {
+		sql = append(sql, s.SQL)
+	}
+	got := strings.Join(sql, "\n")
+	for _, want := range []string{
+		`ALTER TABLE "dest"."transfers" ALTER COLUMN "amount" SET DEFAULT 0`,
+		`ALTER TABLE "dest"."transfers" ADD COLUMN IF NOT EXISTS "sender" text`,
+		`CREATE INDEX IF NOT EXISTS "transfers_sender_idx" ON "dest"."transfers" ("sender")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("plan is missing %s:\n%s", want, got)
+		}
+	}
+}
+
+func TestNewPlanDestructive(t *testing.T) {
+	next := transfers()
+	next.Columns = next.Columns[:2]
+	next.Columns[1].Type = "text"
+
+	plan := NewPlan("dest", []configs.Entity{transfers(), {Name: "old"}}, []configs.Entity{next})
+	var got []string
+	for _, s := range plan.Destructive() {
+		got = append(got, s.Description)
+	}
+	want := []string{
+		"change type of amount from numeric(78,0) to text",
+		"drop column memo",
+		"drop table old",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("destructive = %v, want %v", got, want)
+	}
+}
+
+func TestNewPlanProvenance(t *testing.T) {
+	e := transfers()
+	e.Provenance = true
+	plan := NewPlan("dest", []configs.Entity{transfers()}, []configs.Entity{e})
+	var got []string
+	for _, s := range plan.Statements {
+		got = append(got, s.SQL)
+	}
+	want := []string{
+		`ALTER TABLE "dest"."transfers" ADD COLUMN IF NOT EXISTS "provenance_block" bigint`,
+		`ALTER TABLE "dest"."transfers" ADD COLUMN IF NOT EXISTS "provenance_hash" text`,
+		`ALTER TABLE "dest"."transfers" ADD COLUMN IF NOT EXISTS "provenance_pipeline" text`,
+		`ALTER TABLE "dest"."transfers" ADD COLUMN IF NOT EXISTS "provenance_handler" text`,
+		`CREATE INDEX IF NOT EXISTS "transfers_provenance_block_provenance_pipeline_idx" ON "dest"."transfers" ("provenance_block", "provenance_pipeline")`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if e.RowBlockColumn() != configs.ProvenanceBlockColumn {
+		t.Errorf("RowBlockColumn() = %q", e.RowBlockColumn())
+	}
+}
+
+func TestNewPlanInvalid(t *testing.T) {
+	next := transfers()
+	next.Columns = append(next.Columns,
+		configs.Column{Name: "sender", Type: "text"},
+		configs.Column{Name: "fee", Type: "numeric", Default: "0"},
+	)
+	plan := NewPlan("dest", []configs.Entity{transfers()}, []configs.Entity{next})
+	invalid := plan.Invalid()
+	if len(invalid) != 1 || invalid[0].Description != "add not null column sender without a default" {
+		t.Errorf("invalid = %v, want the sender column", invalid)
+	}
+	if created := NewPlan("dest", nil, []configs.Entity{next}); len(created.Invalid()) != 0 {
+		t.Errorf("create plan invalid = %v, want none", created.Invalid())
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	metadataDB, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	destinationDB, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition, err := json.Marshal([]configs.Entity{transfers()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// latest makes the schema_versions queries return version 1, which
+	// created the entities in the dest schema, or no version.
+	latest := func(found bool) {
+		var rows [][]any
+		if found {
+			rows = append(rows, []any{"project", "pipeline", 1, "dest", string(definition)})
+		}
+		metadataDB.Rows(`"schema_versions" WHERE`, []string{"project", "pipeline", "version", "schema", "definition"}, rows...)
+		metadataDB.Rows("MAX(version)", []string{"max"}, []any{1})
+	}
+	latest(true)
+
+	cfg := &configs.Config{
+		ProjectConfig: configs.ProjectConfig{Name: "project"},
+		PipelineConfig: configs.PipelineConfig{
+			Name:        "pipeline",
+			Destination: configs.Destination{Schema: "dest", Entities: []configs.Entity{transfers()}},
+		},
+	}
+	deps := &utils.Deps{MetadataDB: metadataDB.DB, DestinationDB: destinationDB.DB, Config: cfg}
+	plan, err := Migrate(deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Errorf("Migrate() = %v, want an empty plan", plan.Statements)
+	}
+
+	// The entities do not exist in another schema yet.
+	latest(false)
+	cfg.PipelineConfig.Destination.Schema = "other"
+	plan, err = Migrate(deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Statements) != 2 || !strings.HasPrefix(plan.Statements[0].SQL, `CREATE TABLE IF NOT EXISTS "other"."transfers"`) {
+		t.Errorf("Migrate() to another schema = %v, want the tables created", plan.Statements)
+	}
+	created := metadataDB.Statements(`INSERT INTO "schema_versions"`)
+	if len(created) != 1 || created[0].Args[2] != int64(2) || created[0].Args[3] != "other" {
+		t.Errorf("Migrate() recorded %v, want version 2 of schema other", created)
+	}
+
+	next := transfers()
+	next.Columns = append(next.Columns, configs.Column{Name: "sender", Type: "text"})
+	cfg.PipelineConfig.Destination.Entities = []configs.Entity{next}
+	latest(true)
+	cfg.PipelineConfig.Destination.Schema = "dest"
+	if _, err := Migrate(deps); !errors.Is(err, ErrInvalidMigration) {
+		t.Errorf("Migrate() of a not null column = %v, want ErrInvalidMigration", err)
+	}
+}
+
+func TestMigrateSameDB(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("connection lost")
+	db.Fail(`INSERT INTO "schema_versions"`, failure)
+	cfg := &configs.Config{PipelineConfig: configs.PipelineConfig{
+		Destination: configs.Destination{Schema: "dest", Entities: []configs.Entity{transfers()}},
+	}}
+	if _, err := Migrate(&utils.Deps{MetadataDB: db.DB, DestinationDB: db.DB, Config: cfg}); !errors.Is(err, failure) {
+		t.Fatalf("Migrate() = %v, want %v", err, failure)
+	}
+	// The tables created are rolled back with the version.
+	var steps []string
+	for _, s := range db.Statements("") {
+		switch {
+		case s.SQL == "BEGIN" || s.SQL == "COMMIT" || s.SQL == "ROLLBACK":
+			steps = append(steps, s.SQL)
+		case strings.HasPrefix(s.SQL, "CREATE TABLE"):
+			steps = append(steps, "create")
+		case strings.HasPrefix(s.SQL, `INSERT INTO "schema_versions"`):
+			steps = append(steps, "version")
+		}
+	}
+	if want := []string{"BEGIN", "create", "version", "ROLLBACK"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("Migrate() ran %v, want %v", steps, want)
+	}
+}
+
+func TestMigrateInterrupted(t *testing.T) {
+	metadataDB, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	destinationDB, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("connection lost")
+	metadataDB.Fail(`UPDATE "schema_versions"`, failure)
+	cfg := &configs.Config{PipelineConfig: configs.PipelineConfig{
+		Destination: configs.Destination{Schema: "dest", Entities: []configs.Entity{transfers()}},
+	}}
+	deps := &utils.Deps{MetadataDB: metadataDB.DB, DestinationDB: destinationDB.DB, Config: cfg}
+
+	// The plan is applied but the version cannot be marked applied.
+	if _, err := Migrate(deps); !errors.Is(err, failure) {
+		t.Fatalf("Migrate() = %v, want %v", err, failure)
+	}
+	if n := len(destinationDB.Statements("CREATE TABLE")); n != 1 {
+		t.Errorf("Migrate() created %d tables, want 1", n)
+	}
+	recorded := metadataDB.Statements(`INSERT INTO "schema_versions"`)
+	if len(recorded) != 1 || recorded[0].Args[len(recorded[0].Args)-1] != true {
+		t.Fatalf("Migrate() recorded %v, want a pending version", recorded)
+	}
+
+	// The pending version is not the last applied one: the next run applies
+	// the plan again and replaces it.
+	metadataDB, err = fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps.MetadataDB = metadataDB.DB
+	destinationDB.Reset()
+	plan, err := Migrate(deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Empty() || len(destinationDB.Statements("CREATE TABLE")) != 1 {
+		t.Errorf("Migrate() after an interruption = %v, want the plan applied again", plan.Statements)
+	}
+	for _, match := range []string{"NOT pending", `DELETE FROM "schema_versions"`, `UPDATE "schema_versions" SET "pending"`} {
+		if len(metadataDB.Statements(match)) == 0 {
+			t.Errorf("Migrate() ran no statement with %s", match)
+		}
+	}
+}
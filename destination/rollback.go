@@ -0,0 +1,51 @@
+package destination
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Zettablock/zsource/configs"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// DeleteBlocks deletes the rows written for blocks from to to, inclusive,
+// from the entities that record the block of their rows, see
+// configs.Entity.RowBlockColumn, and returns how many rows it deleted. When
+// pipeline is not empty, only the rows whose provenance is that pipeline are
+// deleted from entities with Provenance set, so that pipelines sharing a
+// table do not delete each other's rows.
+//
+// Rows are deleted by the block they were last written for, as the previous
+// versions of a row are not kept: a row created before from and updated in
+// the range is deleted too. Entities whose rows are updated after they are
+// created should be rebuilt from their start block instead of rolled back.
+func DeleteBlocks(db *gorm.DB, schema string, entities []configs.Entity, pipeline string, from int64, to int64) (int64, error) {
+	var deleted int64
+	for _, e := range entities {
+		column := e.RowBlockColumn()
+		if column == "" {
+			continue
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s BETWEEN ? AND ?", qualify(schema, e.Name), pq.QuoteIdentifier(column))
+		args := []any{from, to}
+		if pipeline != "" && e.Provenance {
+			query += fmt.Sprintf(" AND %s = ?", pq.QuoteIdentifier(configs.ProvenancePipelineColumn))
+			args = append(args, pipeline)
+		}
+		result := db.Exec(query, args...)
+		if result.Error != nil {
+			return deleted, fmt.Errorf("delete %s rows of blocks %d to %d: %w", e.Name, from, to, result.Error)
+		}
+		deleted += result.RowsAffected
+	}
+	return deleted, nil
+}
+
+// DeleteAfter deletes the rows written for blocks after block, like
+// DeleteBlocks. It is how the rows of blocks orphaned by a chain
+// reorganization are rolled back.
+func DeleteAfter(db *gorm.DB, schema string, entities []configs.Entity, pipeline string, block int64) (int64, error) {
+	return DeleteBlocks(db, schema, entities, pipeline, block+1, math.MaxInt64)
+}
@@ -0,0 +1,51 @@
+package destination
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+)
+
+func TestDeleteBlocks(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamped := transfers()
+	stamped.Name = "stamped"
+	stamped.Provenance = true
+	numbered := transfers()
+	numbered.Name = "numbered"
+	numbered.BlockColumn = "block_number"
+	entities := []configs.Entity{transfers(), stamped, numbered}
+
+	deleted, err := DeleteBlocks(db.DB, "dest", entities, "pipeline", 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteBlocks() = %d, want a row of each entity with a block column", deleted)
+	}
+	want := []fakedb.Statement{{
+		SQL:  `DELETE FROM "dest"."stamped" WHERE "provenance_block" BETWEEN $1 AND $2 AND "provenance_pipeline" = $3`,
+		Args: []any{int64(10), int64(20), "pipeline"},
+	}, {
+		SQL:  `DELETE FROM "dest"."numbered" WHERE "block_number" BETWEEN $1 AND $2`,
+		Args: []any{int64(10), int64(20)},
+	}}
+	if got := db.Statements("DELETE"); !reflect.DeepEqual(got, want) {
+		t.Errorf("DeleteBlocks() ran %v, want %v", got, want)
+	}
+
+	// Without a pipeline the rows of every pipeline are deleted.
+	db.Reset()
+	if _, err := DeleteAfter(db.DB, "dest", []configs.Entity{stamped}, "", 10); err != nil {
+		t.Fatal(err)
+	}
+	got := db.Statements("DELETE")
+	if len(got) != 1 || len(got[0].Args) != 2 || got[0].Args[0] != int64(11) {
+		t.Errorf("DeleteAfter() ran %v, want the stamped rows after block 10", got)
+	}
+}
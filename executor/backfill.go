@@ -0,0 +1,191 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/utils"
+)
+
+// BackfillOptions tunes Backfill. Zero values use the defaults.
+type BackfillOptions struct {
+	// Workers is the number of chunks processed concurrently. It defaults to
+	// the number of CPUs.
+	Workers int
+	// ChunkSize is the number of blocks per chunk, 100 by default.
+	ChunkSize int64
+}
+
+const defaultChunkSize = 100
+
+// chunk is a run of consecutive blocks handed to a backfill worker.
+type chunk struct {
+	index    int
+	from, to int64
+	// blocks holds the fetched blocks in scope, in order, once the worker is
+	// done with the chunk.
+	blocks []*blockData
+	err    error
+}
+
+// Backfill processes the blocks in scope after the checkpoint up to block to,
+// or the latest confirmed block of the source if that comes first (see
+// Source.Confirmations), like Run but with the range split into chunks
+// processed by a pool of workers. A chain reorganization found while
+// committing is rolled back, and the backfill starts again from the ancestor.
+//
+// Workers read the source data of their chunk and run the order independent
+// handlers on it, each block in its own transaction. The ordered handlers,
+// the interval handlers and the checkpoint then run on each block in block
+// order, as with Run, so that the checkpoint never passes a block whose
+// ordered writes are not committed. Order independent handlers may therefore
+// run again on blocks after the checkpoint when a backfill is interrupted.
+func (e *Executor) Backfill(ctx context.Context, to int64, opts BackfillOptions) error {
+	if !e.initialized {
+		if err := e.Initialize(ctx, false); err != nil {
+			return err
+		}
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	if _, err := e.checkReorg(ctx); err != nil {
+		return err
+	}
+	latest, err := e.reader.LatestBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	to = min(to, latest-e.deps.Config.PipelineConfig.Source.Confirmations)
+	for {
+		err := e.backfill(ctx, to, opts)
+		var reorg *reorgError
+		if !errors.As(err, &reorg) {
+			return err
+		}
+		rolledBack, checkErr := e.checkReorg(ctx)
+		if checkErr != nil {
+			return checkErr
+		}
+		if !rolledBack {
+			// The source is not consistent yet.
+			return err
+		}
+	}
+}
+
+// backfill runs one pass of Backfill from the checkpoint up to block to. It
+// returns once its workers have stopped, so that a rollback after it does not
+// race with their writes.
+func (e *Executor) backfill(ctx context.Context, to int64, opts BackfillOptions) error {
+	from, err := e.next(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	// tokens bounds how far workers run ahead of the ordered commit.
+	tokens := make(chan struct{}, 2*opts.Workers)
+	chunks := make(chan *chunk)
+	done := make(chan *chunk, 2*opts.Workers)
+
+	go func() {
+		defer close(chunks)
+		index := 0
+		for start := from; start <= to; start += opts.ChunkSize {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			c := &chunk{index: index, from: start, to: min(start+opts.ChunkSize-1, to)}
+			select {
+			case chunks <- c:
+			case <-ctx.Done():
+				return
+			}
+			index++
+		}
+	}()
+
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				c.err = e.prepareChunk(ctx, c)
+				done <- c
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Commit chunks in order, holding back the ones that finish early.
+	pending := map[int]*chunk{}
+	nextIndex := 0
+	for c := range done {
+		pending[c.index] = c
+		for {
+			c, ok := pending[nextIndex]
+			if !ok {
+				break
+			}
+			delete(pending, nextIndex)
+			if c.err != nil {
+				return c.err
+			}
+			for _, data := range c.blocks {
+				if err := e.processWithRetry(ctx, data.block.Number, data, selection{phase: orderedHandlers}, e.advance(ctx)); err != nil {
+					return err
+				}
+			}
+			nextIndex++
+			<-tokens
+		}
+	}
+	return ctx.Err()
+}
+
+// prepareChunk fetches the blocks of a chunk that are in scope and runs the
+// order independent handlers on them.
+func (e *Executor) prepareChunk(ctx context.Context, c *chunk) error {
+	for n := c.from; n <= c.to; n++ {
+		number, ok := e.deps.Config.NextInScope(n)
+		if !ok || number > c.to {
+			return nil
+		}
+		n = number
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := e.fetch(ctx, number)
+		if err != nil {
+			return err
+		}
+		if len(e.unordered) > 0 {
+			err := e.processWithRetry(ctx, number, data, selection{phase: unorderedHandlers}, noCommit)
+			if err != nil {
+				return err
+			}
+		}
+		c.blocks = append(c.blocks, data)
+	}
+	return nil
+}
+
+func noCommit(tx *utils.Deps, block *ethereum.Block) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+
+	"gorm.io/gorm"
+)
+
+// Checkpoints records the last block a pipeline fully processed. The executor
+// resumes after it. checkpoint.Store keeps checkpoints in the metadata db.
+type Checkpoints interface {
+	// Last returns the number of the last processed block, and false if no
+	// block was processed yet.
+	Last(ctx context.Context) (int64, bool, error)
+	// Advance records block as processed. metadataDB is the metadata
+	// transaction of the block, so that the checkpoint only advances if the
+	// block commits. It is nil when Deps has no MetadataDB.
+	Advance(ctx context.Context, metadataDB *gorm.DB, block *ethereum.Block) error
+	// Rewind moves the checkpoint back to block, after a chain
+	// reorganization orphaned the blocks after it. metadataDB is as for
+	// Advance.
+	Rewind(ctx context.Context, metadataDB *gorm.DB, block *ethereum.Block) error
+}
+
+// MemoryCheckpoints keeps the checkpoint in memory, so a pipeline using it
+// starts over on every run. It is not rolled back with the block
+// transaction, which makes it suitable for tests only.
+type MemoryCheckpoints struct {
+	mu     sync.Mutex
+	number int64
+	ok     bool
+}
+
+func (c *MemoryCheckpoints) Last(ctx context.Context) (int64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.number, c.ok, nil
+}
+
+func (c *MemoryCheckpoints) Advance(ctx context.Context, metadataDB *gorm.DB, block *ethereum.Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.number, c.ok = block.Number, true
+	return nil
+}
+
+func (c *MemoryCheckpoints) Rewind(ctx context.Context, metadataDB *gorm.DB, block *ethereum.Block) error {
+	return c.Advance(ctx, metadataDB, block)
+}
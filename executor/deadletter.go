@@ -0,0 +1,96 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/metadata"
+	"github.com/Zettablock/zsource/utils"
+)
+
+var errNoMetadataDB = errors.New("dead letters need a metadata db")
+
+// deadLetter returns the dead letter of the handler of handlerErr, which
+// failed attempts times on block number.
+func (e *Executor) deadLetter(handlerErr *utils.HandlerError, number int64, attempts int) (metadata.DeadLetter, error) {
+	if e.deps.MetadataDB == nil {
+		return metadata.DeadLetter{}, fmt.Errorf("%w: %v", errNoMetadataDB, handlerErr)
+	}
+	return metadata.DeadLetter{
+		Project:     e.deps.Config.GetProjectName(),
+		Pipeline:    e.deps.Config.GetPipelineName(),
+		Handler:     handlerErr.Name,
+		BlockNumber: number,
+		Kind:        string(handlerErr.Kind),
+		Error:       handlerErr.Error(),
+		Attempts:    attempts,
+		FailedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// saveDeadLetters returns commit preceded by saving letters, so that they are
+// saved in the transaction of the block processed without their handlers,
+// and only if it commits.
+func saveDeadLetters(ctx context.Context, letters []metadata.DeadLetter, commit func(tx *utils.Deps, block *ethereum.Block) error) func(tx *utils.Deps, block *ethereum.Block) error {
+	if len(letters) == 0 {
+		return commit
+	}
+	return func(tx *utils.Deps, block *ethereum.Block) error {
+		if err := tx.MetadataDB.WithContext(ctx).Save(&letters).Error; err != nil {
+			return err
+		}
+		return commit(tx, block)
+	}
+}
+
+// DeadLetters returns the dead-lettered blocks of the pipeline, of every
+// handler or of handler when it is not empty, in block order.
+func (e *Executor) DeadLetters(ctx context.Context, handler string) ([]metadata.DeadLetter, error) {
+	if e.deps.MetadataDB == nil {
+		return nil, errNoMetadataDB
+	}
+	query := e.deps.MetadataDB.WithContext(ctx).
+		Where("project = ? AND pipeline = ?", e.deps.Config.GetProjectName(), e.deps.Config.GetPipelineName())
+	if handler != "" {
+		query = query.Where("handler = ?", handler)
+	}
+	var letters []metadata.DeadLetter
+	err := query.Order("block_number, handler").Find(&letters).Error
+	return letters, err
+}
+
+// Replay runs dead-lettered handlers again on their blocks, in block order,
+// once each and without retries. A dead letter is deleted in the transaction
+// of its successful replay; a failed replay updates its error and attempts.
+// It returns how many dead letters were replayed, and the replay errors.
+func (e *Executor) Replay(ctx context.Context, handler string) (int, error) {
+	letters, err := e.DeadLetters(ctx, handler)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	var errs []error
+	for _, letter := range letters {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+		err := e.processBlock(ctx, letter.BlockNumber, selection{only: letter.Handler}, func(tx *utils.Deps, block *ethereum.Block) error {
+			return tx.MetadataDB.Delete(&letter).Error
+		})
+		if err == nil {
+			replayed++
+			continue
+		}
+		errs = append(errs, fmt.Errorf("replay block %d: %w", letter.BlockNumber, err))
+		letter.Error = err.Error()
+		letter.Attempts++
+		letter.FailedAt = time.Now().UTC()
+		if err := e.deps.MetadataDB.WithContext(ctx).Save(&letter).Error; err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, errors.Join(errs...)
+}
@@ -0,0 +1,553 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+	"github.com/Zettablock/zsource/dao/metadata"
+	"github.com/Zettablock/zsource/source"
+	"github.com/Zettablock/zsource/utils"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// Executor runs the handlers of a pipeline over the blocks of its source, in
+// block order. For each block it runs, in this order, the event handlers of
+// the pipeline and its templates for every log, the call handlers for every
+// call, the block handlers and the interval handlers, and then advances the
+// checkpoint. A handler error rolls the block back and stops the executor, so
+// the block is processed again on the next run. Blocks orphaned by a chain
+// reorganization are rolled back and the new chain processed, see reorg.go.
+type Executor struct {
+	deps        *utils.Deps
+	reader      source.Reader
+	checkpoints Checkpoints
+
+	events []eventRoute
+	// logQueries select the logs of the event handlers when the reader
+	// filters logs in the source, see source.LogFilterer.
+	logQueries []source.LogQuery
+	calls      *utils.CallMatcher
+	blocks     []string
+	intervals  *utils.IntervalScheduler
+
+	// addresses are the contracts the pipeline event handlers listen to, nil
+	// for any contract.
+	addresses map[string]bool
+	// templates maps template names to their contract addresses, from the
+	// config and from the templates metadata table.
+	templates map[string]map[string]bool
+	// unordered lists the handlers declared order independent.
+	unordered map[string]bool
+	// initialized is set once Initialize succeeded.
+	initialized bool
+	lag         lagState
+	// chain holds the last processed blocks, oldest first, when there is no
+	// metadata db to record them in. See reorg.go.
+	chain []metadata.ProcessedBlock
+}
+
+// eventRoute is an event handler compiled for matching logs.
+type eventRoute struct {
+	handler string
+	filter  configs.TopicFilter
+	// template is the template the handler belongs to, "" for pipeline
+	// handlers.
+	template string
+}
+
+// New prepares an executor for the pipeline of deps.Config. Every handler the
+// config references must be in deps.Handlers, or the deprecated
+// deps.TemplateHandlers, with the right signature. A nil checkpoints keeps the
+// checkpoint in memory. The destination db is set up to stamp the provenance
+// of rows, see utils.Provenance.
+func New(deps *utils.Deps, reader source.Reader, checkpoints Checkpoints) (*Executor, error) {
+	if err := deps.RegisterTemplateHandlers(); err != nil {
+		return nil, err
+	}
+	if err := deps.Handlers.Check(deps.Config); err != nil {
+		return nil, err
+	}
+	if deps.DestinationDB != nil {
+		if err := utils.UseProvenance(deps.DestinationDB); err != nil {
+			return nil, err
+		}
+	}
+	if checkpoints == nil {
+		checkpoints = &MemoryCheckpoints{}
+	}
+	p := deps.Config.PipelineConfig
+	e := &Executor{
+		deps:        deps,
+		reader:      reader,
+		checkpoints: checkpoints,
+		intervals:   utils.NewIntervalScheduler(p.IntervalHandlers),
+		addresses:   addressSet(p.Source.Addresses),
+		templates:   map[string]map[string]bool{},
+		unordered:   map[string]bool{},
+	}
+	for _, h := range p.EventHandlers {
+		if h.OrderIndependent {
+			e.unordered[h.Handler] = true
+		}
+	}
+	for _, h := range p.CallHandlers {
+		if h.OrderIndependent {
+			e.unordered[h.Handler] = true
+		}
+	}
+	for _, h := range p.BlockHandlers {
+		if h.OrderIndependent {
+			e.unordered[h.Handler] = true
+		}
+	}
+	// Template handlers depend on the addresses saved by earlier blocks.
+	for _, t := range p.Templates {
+		for _, h := range t.EventHandlers {
+			delete(e.unordered, h.Handler)
+		}
+	}
+
+	routes, err := e.compileEvents(p.Source.ABIFile, p.EventHandlers, "")
+	if err != nil {
+		return nil, err
+	}
+	e.events = append(e.events, routes...)
+	for _, t := range p.Templates {
+		routes, err := e.compileEvents(t.ABIFile, t.EventHandlers, t.Name)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", t.Name, err)
+		}
+		e.events = append(e.events, routes...)
+		e.templates[t.Name] = addressSet(t.Addresses)
+	}
+	for _, route := range e.events {
+		q := source.LogQuery{Topics: route.filter}
+		// Template addresses are saved while blocks are processed, after
+		// their logs may have been read, so template logs are only selected
+		// by topics.
+		if route.template == "" {
+			q.Addresses = p.Source.Addresses
+		}
+		e.logQueries = append(e.logQueries, q)
+	}
+
+	e.calls, err = utils.NewCallMatcher(p.CallHandlers, p.Source.Addresses)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range p.BlockHandlers {
+		e.blocks = append(e.blocks, h.Handler)
+	}
+	return e, nil
+}
+
+// Run processes the blocks in scope after the checkpoint, up to the latest
+// block of the source or the end of the last block range. It returns nil once
+// caught up. The initialization handlers run first, see Initialize.
+func (e *Executor) Run(ctx context.Context) error {
+	if !e.initialized {
+		if err := e.Initialize(ctx, false); err != nil {
+			return err
+		}
+	}
+	latest, err := e.reader.LatestBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = e.runTo(ctx, latest)
+	return err
+}
+
+// runTo processes the blocks in scope after the checkpoint up to block
+// target. It reports whether the pipeline is finished, i.e. no block after
+// target is in scope. Chain reorganizations are rolled back first, and again
+// whenever a block does not extend the processed ones.
+func (e *Executor) runTo(ctx context.Context, target int64) (bool, error) {
+	if _, err := e.checkReorg(ctx); err != nil {
+		return false, err
+	}
+	next, err := e.next(ctx)
+	if err != nil {
+		return false, err
+	}
+	for {
+		number, ok := e.deps.Config.NextInScope(next)
+		if !ok {
+			return true, nil
+		}
+		if number > target {
+			return false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		err := e.ProcessBlock(ctx, number)
+		var reorg *reorgError
+		if errors.As(err, &reorg) {
+			rolledBack, checkErr := e.checkReorg(ctx)
+			if checkErr != nil {
+				return false, checkErr
+			}
+			if !rolledBack {
+				// The source is not consistent yet.
+				return false, err
+			}
+			if next, err = e.next(ctx); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		next = number + 1
+	}
+}
+
+// next returns the first block to process: the one after the checkpoint, or
+// the start block.
+func (e *Executor) next(ctx context.Context) (int64, error) {
+	last, ok, err := e.checkpoints.Last(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return e.deps.Config.GetStartBlock(), nil
+	}
+	return last + 1, nil
+}
+
+// ProcessBlock runs every handler on block number and advances the
+// checkpoint, all in one transaction (see utils.Deps.Transaction): if any
+// handler fails, none of the writes made for the block are kept. Failures are
+// retried according to the retry policy of the failing handler, see retry.go.
+func (e *Executor) ProcessBlock(ctx context.Context, number int64) error {
+	return e.processWithRetry(ctx, number, nil, selection{}, e.advance(ctx))
+}
+
+// phase splits the handlers of a block for Backfill.
+type phase int
+
+const (
+	// allHandlers runs every handler.
+	allHandlers phase = iota
+	// orderedHandlers runs the handlers that are not order independent, and
+	// the interval handlers.
+	orderedHandlers
+	// unorderedHandlers runs the order independent handlers only.
+	unorderedHandlers
+)
+
+// selection picks the handlers processBlock runs.
+type selection struct {
+	phase phase
+	// skip lists handlers that are dead-lettered for the block.
+	skip map[string]bool
+	// only is the single handler to run when replaying a dead letter, on the
+	// interval of the block for an interval handler. The checkpoint and the
+	// interval states are left alone then.
+	only string
+}
+
+// runs reports whether the selection runs the event, call or block handler
+// called name.
+func (e *Executor) runs(sel selection, name string) bool {
+	if sel.only != "" {
+		return name == sel.only
+	}
+	if sel.skip[name] {
+		return false
+	}
+	switch sel.phase {
+	case orderedHandlers:
+		return !e.unordered[name]
+	case unorderedHandlers:
+		return e.unordered[name]
+	}
+	return true
+}
+
+// runsIntervals reports whether the selection runs the interval handlers.
+func (sel selection) runsIntervals() bool {
+	return sel.only == "" && sel.phase != unorderedHandlers
+}
+
+// blockData is the source data the handlers of a block run on.
+type blockData struct {
+	block *ethereum.Block
+	logs  []*ethereum.Log
+	calls []*utils.Call
+}
+
+// fetch reads the source data of block number that the handlers need.
+func (e *Executor) fetch(ctx context.Context, number int64) (*blockData, error) {
+	needsCalls := len(e.deps.Config.PipelineConfig.CallHandlers) > 0
+	if fetcher, ok := e.reader.(source.BlockFetcher); ok && (len(e.events) > 0 || needsCalls) {
+		// One fetch of the block serves its logs, transactions and traces.
+		all, err := fetcher.BlockData(ctx, number, needsCalls && e.calls.NeedsTraces())
+		if err != nil {
+			return nil, err
+		}
+		data := &blockData{block: all.Block}
+		if len(e.events) > 0 {
+			data.logs = all.Logs
+		}
+		if needsCalls {
+			data.calls = utils.NewCalls(all.Transactions, all.Traces)
+		}
+		return data, nil
+	}
+
+	block, err := e.reader.Block(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	data := &blockData{block: block}
+	if filterer, ok := e.reader.(source.LogFilterer); ok && len(e.events) > 0 {
+		if data.logs, err = filterer.FilterLogs(ctx, number, e.logQueries); err != nil {
+			return nil, err
+		}
+	} else if len(e.events) > 0 {
+		if data.logs, err = e.reader.Logs(ctx, number); err != nil {
+			return nil, err
+		}
+	}
+	if needsCalls {
+		txs, err := e.reader.Transactions(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		var traces []*ethereum.Trace
+		if e.calls.NeedsTraces() {
+			if traces, err = e.reader.Traces(ctx, number); err != nil {
+				return nil, err
+			}
+		}
+		data.calls = utils.NewCalls(txs, traces)
+	}
+	return data, nil
+}
+
+// processBlock fetches block number and applies the selected handlers to it.
+func (e *Executor) processBlock(ctx context.Context, number int64, sel selection, commit func(tx *utils.Deps, block *ethereum.Block) error) error {
+	data, err := e.fetch(ctx, number)
+	if err != nil {
+		return err
+	}
+	return e.apply(ctx, data, sel, commit)
+}
+
+// apply runs the selected handlers on a block, then commit, in one
+// transaction.
+func (e *Executor) apply(ctx context.Context, data *blockData, sel selection, commit func(tx *utils.Deps, block *ethereum.Block) error) error {
+	// Order independent handlers run concurrently with the ordered ones of
+	// earlier blocks, so template addresses are only maintained by the
+	// latter, which template handlers always are.
+	if sel.phase != unorderedHandlers {
+		if err := e.loadTemplates(); err != nil {
+			return err
+		}
+	}
+	number := data.block.Number
+	skip := func(name string) bool { return !e.runs(sel, name) }
+
+	handled := 0
+	err := e.deps.Transaction(func(tx *utils.Deps) error {
+		tx.Block = data.block
+		n, err := e.runEventHandlers(tx, data.logs, sel)
+		handled += n
+		if err != nil {
+			return err
+		}
+		n, err = tx.RunCallHandlers(e.calls, data.calls, skip)
+		handled += n
+		if err != nil {
+			return err
+		}
+		for _, name := range e.blocks {
+			if skip(name) {
+				continue
+			}
+			handler, err := tx.Handlers.Block(name)
+			if err != nil {
+				return err
+			}
+			ok, err := handler(number, tx.WithHandler(name))
+			if err != nil {
+				return &utils.HandlerError{Kind: utils.KindBlock, Name: name, At: fmt.Sprintf("block %d", number), Err: err}
+			}
+			if ok {
+				handled++
+			}
+		}
+		switch {
+		case sel.runsIntervals():
+			n, err = e.intervals.Run(tx, data.block, func(name string) bool { return sel.skip[name] })
+		case sel.only != "":
+			n, err = e.intervals.Replay(tx, data.block, sel.only)
+		}
+		handled += n
+		if err != nil {
+			return err
+		}
+		return commit(tx, data.block)
+	})
+	if err != nil {
+		if sel.runsIntervals() {
+			// The interval states written in the transaction are gone.
+			e.intervals.Reset()
+		}
+		return err
+	}
+	if e.deps.Logger != nil {
+		e.deps.Logger.Debug("processed block", "block", number, "handled", handled)
+	}
+	return nil
+}
+
+// advance is the commit step of regular blocks.
+func (e *Executor) advance(ctx context.Context) func(tx *utils.Deps, block *ethereum.Block) error {
+	return func(tx *utils.Deps, block *ethereum.Block) error {
+		if err := e.recordBlock(ctx, tx, block); err != nil {
+			return err
+		}
+		return e.checkpoints.Advance(ctx, tx.MetadataDB, block)
+	}
+}
+
+// runEventHandlers runs the matching event handlers for each of logs, in
+// order, and returns how many reported acting on their log.
+func (e *Executor) runEventHandlers(d *utils.Deps, logs []*ethereum.Log, sel selection) (int, error) {
+	handled := 0
+	for _, log := range logs {
+		if log.Removed {
+			continue
+		}
+		address := strings.ToLower(log.ContractAddress)
+		for _, route := range e.events {
+			if !e.runs(sel, route.handler) {
+				continue
+			}
+			if route.template == "" {
+				if e.addresses != nil && !e.addresses[address] {
+					continue
+				}
+			} else if sel.phase == unorderedHandlers || !e.templates[route.template][address] {
+				continue
+			}
+			if !route.filter.Match(log.Topics) {
+				continue
+			}
+			handler, err := d.Handlers.Event(route.handler)
+			if err != nil {
+				return handled, err
+			}
+			ok, err := handler(log, d.WithHandler(route.handler))
+			if err != nil {
+				return handled, &utils.HandlerError{Kind: utils.KindEvent, Name: route.handler, At: fmt.Sprintf("log %d of block %d", log.LogIndex, log.BlockNumber), Err: err}
+			}
+			if ok {
+				handled++
+			}
+		}
+	}
+	return handled, nil
+}
+
+// loadTemplates adds the template addresses the pipeline saved with
+// Deps.SaveTemplate. It runs before every block so that addresses saved by the handlers of a
+// block are listened to from the next block on.
+func (e *Executor) loadTemplates() error {
+	if len(e.templates) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.templates))
+	for name := range e.templates {
+		names = append(names, name)
+	}
+	var rows []evm.Template
+	pipeline := e.deps.Config.GetPipelineName()
+	if err := e.deps.MetadataDB.Where("name IN ? AND pipeline = ?", names, pipeline).Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		addresses := e.templates[row.Name]
+		if addresses == nil {
+			addresses = map[string]bool{}
+			e.templates[row.Name] = addresses
+		}
+		addresses[strings.ToLower(row.ContractAddress)] = true
+	}
+	return nil
+}
+
+// compileEvents resolves the events of handlers, against the ABI in abiFile
+// when set or else from their signatures.
+func (e *Executor) compileEvents(abiFile string, handlers []configs.EventHandler, template string) ([]eventRoute, error) {
+	if len(handlers) == 0 {
+		return nil, nil
+	}
+	var contractAbi *abi.ABI
+	if abiFile != "" {
+		loaded, err := e.deps.LoadABIByName(abiFile)
+		if err != nil {
+			return nil, fmt.Errorf("abi %s: %w", abiFile, err)
+		}
+		contractAbi = &loaded
+	}
+	routes := make([]eventRoute, 0, len(handlers))
+	for _, h := range handlers {
+		event, err := resolveEvent(contractAbi, h.Event)
+		if err != nil {
+			return nil, fmt.Errorf("event handler %s: %w", h.Handler, err)
+		}
+		filter, err := configs.CompileFilter(event, h.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("event handler %s: %w", h.Handler, err)
+		}
+		routes = append(routes, eventRoute{handler: h.Handler, filter: filter, template: template})
+	}
+	return routes, nil
+}
+
+// resolveEvent finds event in contractAbi or, without an ABI, builds it from
+// its signature, which then needs its parameter types.
+func resolveEvent(contractAbi *abi.ABI, event string) (*abi.Event, error) {
+	if contractAbi != nil {
+		return configs.FindEvent(*contractAbi, event)
+	}
+	ref, err := configs.ParseEventRef(event)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Params == nil {
+		return nil, errors.New("event " + event + " needs an abiFile or a full signature")
+	}
+	inputs := make(abi.Arguments, 0, len(ref.Params))
+	for i, param := range ref.Params {
+		t, err := abi.NewType(param.Type, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %w", event, err)
+		}
+		inputs = append(inputs, abi.Argument{Name: fmt.Sprintf("arg%d", i), Type: t, Indexed: param.Indexed})
+	}
+	built := abi.NewEvent(ref.Name, ref.Name, false, inputs)
+	return &built, nil
+}
+
+func addressSet(addresses []string) map[string]bool {
+	if len(addresses) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		set[strings.ToLower(address)] = true
+	}
+	return set
+}
@@ -0,0 +1,792 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/source"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+	"github.com/Zettablock/zsource/utils"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	testToken    = "0x00000000000000000000000000000000000000aa"
+	transferID   = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	otherEventID = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+)
+
+// memReader serves blocks and logs from memory.
+type memReader struct {
+	mu     sync.Mutex
+	blocks map[int64]*ethereum.Block
+	logs   map[int64][]*ethereum.Log
+	notify chan struct{}
+}
+
+// add adds a block and notifies listeners.
+func (r *memReader) add(block *ethereum.Block) {
+	r.mu.Lock()
+	r.blocks[block.Number] = block
+	notify := r.notify
+	r.mu.Unlock()
+	if notify != nil {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *memReader) Notifications(ctx context.Context, channel string) (<-chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notify = make(chan struct{}, 1)
+	return r.notify, nil
+}
+
+func (r *memReader) LatestBlockNumber(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest int64
+	for n := range r.blocks {
+		latest = max(latest, n)
+	}
+	return latest, nil
+}
+
+func (r *memReader) Block(ctx context.Context, number int64) (*ethereum.Block, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	block, ok := r.blocks[number]
+	if !ok {
+		return nil, source.ErrBlockNotFound
+	}
+	return block, nil
+}
+
+func (r *memReader) Transactions(ctx context.Context, number int64) ([]*ethereum.Transaction, error) {
+	return nil, nil
+}
+
+func (r *memReader) Logs(ctx context.Context, number int64) ([]*ethereum.Log, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.logs[number], nil
+}
+
+func (r *memReader) Traces(ctx context.Context, number int64) ([]*ethereum.Trace, error) {
+	return nil, nil
+}
+
+func newTestReader() *memReader {
+	r := &memReader{blocks: map[int64]*ethereum.Block{}, logs: map[int64][]*ethereum.Log{}}
+	for n := int64(1); n <= 5; n++ {
+		r.blocks[n] = &ethereum.Block{Number: n, Hash: fmt.Sprintf("0x%02x", n)}
+	}
+	r.logs[2] = []*ethereum.Log{
+		{BlockNumber: 2, LogIndex: 0, ContractAddress: "0x00000000000000000000000000000000000000AA", Topics: []string{transferID}},
+		{BlockNumber: 2, LogIndex: 1, ContractAddress: testToken, Topics: []string{otherEventID}},
+		{BlockNumber: 2, LogIndex: 2, ContractAddress: "0x01", Topics: []string{transferID}},
+		{BlockNumber: 2, LogIndex: 3, ContractAddress: testToken, Topics: []string{transferID}, Removed: true},
+	}
+	r.logs[4] = []*ethereum.Log{
+		{BlockNumber: 4, LogIndex: 7, ContractAddress: testToken, Topics: []string{transferID}},
+	}
+	return r
+}
+
+func newTestConfig() *configs.Config {
+	two := int64(2)
+	return &configs.Config{PipelineConfig: configs.PipelineConfig{
+		Source: configs.Source{
+			Ranges:    []configs.BlockRange{{Start: 2, End: &two}, {Start: 4}},
+			Addresses: []string{testToken},
+		},
+		EventHandlers: []configs.EventHandler{
+			{Event: "Transfer(address indexed,address indexed,uint256)", Handler: "HandleTransfer"},
+		},
+		BlockHandlers: []configs.BlockHandler{{Handler: "HandleBlock"}},
+	}}
+}
+
+// recorder records the calls of test handlers.
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+// take returns the calls recorded since the last take.
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+// testSetup is what a test changes in the executor newTestExecutor makes.
+type testSetup struct {
+	// reader is newTestReader() when nil.
+	reader source.Reader
+	// checkpoints are kept in memory when nil.
+	checkpoints Checkpoints
+	// rec records the handler calls; a new recorder when nil.
+	rec *recorder
+	// handlers are registered in addition to, or instead of, the
+	// HandleTransfer and HandleBlock handlers that record their calls.
+	handlers map[string]any
+	// configure changes the deps, whose Config is newTestConfig(), before the
+	// executor is made.
+	configure func(deps *utils.Deps)
+}
+
+// newTestExecutor makes an executor for newTestConfig as changed by setup,
+// and returns it with the recorder of its handler calls.
+func newTestExecutor(t *testing.T, setup testSetup) (*Executor, *recorder) {
+	t.Helper()
+	rec := setup.rec
+	if rec == nil {
+		rec = &recorder{}
+	}
+	handlers := map[string]any{
+		"HandleTransfer": func(log *ethereum.Log, deps *utils.Deps) (bool, error) {
+			rec.add("transfer %d/%d", log.BlockNumber, log.LogIndex)
+			return true, nil
+		},
+		"HandleBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+			rec.add("block %d", blockNumber)
+			return true, nil
+		},
+	}
+	for name, handler := range setup.handlers {
+		handlers[name] = handler
+	}
+	registry := utils.NewRegistry()
+	for name, handler := range handlers {
+		if err := registry.Register(name, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deps := &utils.Deps{Config: newTestConfig(), Handlers: registry}
+	if setup.configure != nil {
+		setup.configure(deps)
+	}
+	reader := setup.reader
+	if reader == nil {
+		reader = newTestReader()
+	}
+	e, err := New(deps, reader, setup.checkpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, rec
+}
+
+func TestExecutorRun(t *testing.T) {
+	checkpoints := &MemoryCheckpoints{}
+	e, rec := newTestExecutor(t, testSetup{checkpoints: checkpoints})
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"transfer 2/0", "block 2", "transfer 4/7", "block 4", "block 5"}
+	if got := rec.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if last, ok, _ := checkpoints.Last(context.Background()); !ok || last != 5 {
+		t.Errorf("checkpoint = %d, %v, want 5", last, ok)
+	}
+
+	// A second run has nothing left to do.
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.take(); len(got) != 0 {
+		t.Errorf("second run got %v", got)
+	}
+}
+
+// filteringReader is a memReader that filters logs like source.DBReader.
+type filteringReader struct {
+	*memReader
+	queries [][]source.LogQuery
+}
+
+func (r *filteringReader) FilterLogs(ctx context.Context, number int64, queries []source.LogQuery) ([]*ethereum.Log, error) {
+	r.queries = append(r.queries, queries)
+	logs, _ := r.Logs(ctx, number)
+	var matched []*ethereum.Log
+	for _, log := range logs {
+		for _, q := range queries {
+			if (len(q.Addresses) == 0 || addressSet(q.Addresses)[strings.ToLower(log.ContractAddress)]) && q.Topics.Match(log.Topics) {
+				matched = append(matched, log)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func TestExecutorFilterLogs(t *testing.T) {
+	reader := &filteringReader{memReader: newTestReader()}
+	e, rec := newTestExecutor(t, testSetup{reader: reader})
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.take(), []string{"transfer 2/0", "block 2", "transfer 4/7", "block 4", "block 5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := []source.LogQuery{{Addresses: []string{testToken}, Topics: configs.TopicFilter{{transferID}}}}
+	if len(reader.queries) != 3 || !reflect.DeepEqual(reader.queries[0], want) {
+		t.Errorf("FilterLogs() queries = %v, want %v for each of blocks 2, 4 and 5", reader.queries, want)
+	}
+}
+
+// fetchingReader is a memReader that fetches whole blocks like
+// source.RPCReader, and counts the fetches.
+type fetchingReader struct {
+	*memReader
+	fetches map[int64]int
+	reads   int
+}
+
+func (r *fetchingReader) BlockData(ctx context.Context, number int64, withTraces bool) (*source.BlockData, error) {
+	r.fetches[number]++
+	block, err := r.Block(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	logs, _ := r.memReader.Logs(ctx, number)
+	return &source.BlockData{Block: block, Logs: logs}, nil
+}
+
+func (r *fetchingReader) Logs(ctx context.Context, number int64) ([]*ethereum.Log, error) {
+	r.reads++
+	return r.memReader.Logs(ctx, number)
+}
+
+func (r *fetchingReader) Transactions(ctx context.Context, number int64) ([]*ethereum.Transaction, error) {
+	r.reads++
+	return nil, nil
+}
+
+func TestExecutorFetchBlockData(t *testing.T) {
+	reader := &fetchingReader{memReader: newTestReader(), fetches: map[int64]int{}}
+	e, rec := newTestExecutor(t, testSetup{
+		reader: reader,
+		handlers: map[string]any{
+			"HandleApprove": func(call *utils.Call, deps *utils.Deps) (bool, error) {
+				return true, nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deps.Config.PipelineConfig.CallHandlers = []configs.CallHandler{{Function: "approve(address,uint256)", Handler: "HandleApprove"}}
+		},
+	})
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.take(), []string{"transfer 2/0", "block 2", "transfer 4/7", "block 4", "block 5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if want := map[int64]int{2: 1, 4: 1, 5: 1}; !reflect.DeepEqual(reader.fetches, want) || reader.reads != 0 {
+		t.Errorf("BlockData() fetches = %v and %d other reads, want %v and none", reader.fetches, reader.reads, want)
+	}
+}
+
+func TestExecutorHandlerError(t *testing.T) {
+	failure := errors.New("failure")
+	checkpoints := &MemoryCheckpoints{}
+	e, _ := newTestExecutor(t, testSetup{
+		checkpoints: checkpoints,
+		handlers: map[string]any{
+			"HandleBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+				if blockNumber == 4 {
+					return false, failure
+				}
+				return true, nil
+			},
+		},
+	})
+	if err := e.Run(context.Background()); !errors.Is(err, failure) {
+		t.Fatalf("Run() = %v, want %v", err, failure)
+	}
+	if last, _, _ := checkpoints.Last(context.Background()); last != 2 {
+		t.Errorf("checkpoint = %d, want 2", last)
+	}
+}
+
+func TestNewMissingHandler(t *testing.T) {
+	_, err := New(&utils.Deps{Config: newTestConfig(), Handlers: utils.NewRegistry()}, newTestReader(), nil)
+	if !errors.Is(err, utils.ErrHandlerNotFound) {
+		t.Errorf("New() = %v, want ErrHandlerNotFound", err)
+	}
+}
+
+func TestExecutorRetry(t *testing.T) {
+	calls := 0
+	e, _ := newTestExecutor(t, testSetup{
+		handlers: map[string]any{
+			"HandleBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+				if blockNumber != 2 {
+					return true, nil
+				}
+				calls++
+				switch calls {
+				case 1:
+					return false, Retryable(errors.New("flaky"))
+				case 2:
+					return false, syscall.ECONNRESET
+				}
+				return true, nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deps.Config.PipelineConfig.Retry = configs.RetryPolicy{InitialBackoff: time.Millisecond}
+		},
+	})
+	if err := e.ProcessBlock(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+
+	calls = 0
+	e.deps.Config.PipelineConfig.BlockHandlers[0].Retry = &configs.RetryPolicy{MaxAttempts: 2}
+	if err := e.ProcessBlock(context.Background(), 2); err == nil {
+		t.Error("ProcessBlock() = nil after exhausting attempts")
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestExecutorDeadLetter(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := newTestExecutor(t, testSetup{
+		handlers: map[string]any{
+			"HandleBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+				return false, errors.New("broken")
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deadLetter := true
+			deps.Config.PipelineConfig.BlockHandlers[0].Retry = &configs.RetryPolicy{DeadLetter: &deadLetter}
+			deps.MetadataDB = db.DB
+		},
+	})
+	if err := e.ProcessBlock(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dead letter is saved in the transaction of the block processed
+	// without the handler, with the record of the block.
+	var statements []string
+	for _, s := range db.Statements("") {
+		switch {
+		case s.SQL == "BEGIN" || s.SQL == "COMMIT" || s.SQL == "ROLLBACK":
+			statements = append(statements, s.SQL)
+		case strings.Contains(s.SQL, `"dead_letters"`):
+			statements = append(statements, "dead letter")
+		case strings.HasPrefix(s.SQL, `INSERT INTO "processed_blocks"`):
+			statements = append(statements, "processed block")
+		}
+	}
+	want := []string{"BEGIN", "ROLLBACK", "BEGIN", "dead letter", "processed block", "COMMIT"}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("ProcessBlock() ran %v, want %v", statements, want)
+	}
+}
+
+func TestExecutorDeadLetterInterval(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	broken := true
+	e, _ := newTestExecutor(t, testSetup{
+		rec: rec,
+		handlers: map[string]any{
+			"HandleEveryTwo": func(tick *utils.IntervalTick, deps *utils.Deps) (bool, error) {
+				rec.add("tick %d of block %d", tick.Bucket, tick.Block.Number)
+				if broken {
+					return false, errors.New("broken")
+				}
+				return true, nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deadLetter := true
+			deps.Config.PipelineConfig.IntervalHandlers = []configs.IntervalHandler{
+				{Handler: "HandleEveryTwo", EveryBlocks: 2, Retry: &configs.RetryPolicy{MaxAttempts: 1, DeadLetter: &deadLetter}},
+			}
+			deps.MetadataDB = db.DB
+		},
+	})
+	if err := e.ProcessBlock(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.take(), []string{"transfer 2/0", "block 2", "tick 1 of block 2", "transfer 2/0", "block 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The interval is recorded as done with its dead letter, so the handler is
+	// not due again before its next interval.
+	letters := db.Statements(`INSERT INTO "dead_letters"`)
+	if len(letters) != 1 || letters[0].Args[2] != "HandleEveryTwo" || letters[0].Args[4] != string(utils.KindInterval) {
+		t.Errorf("dead letters %v, want one of interval handler HandleEveryTwo", letters)
+	}
+	if states := db.Statements(`"interval_states"`); len(states) == 0 {
+		t.Error("the state of the dead-lettered interval handler was not advanced")
+	}
+
+	// The replay runs the handler on the interval of its block, and leaves
+	// the interval states alone.
+	broken = false
+	db.Rows(`FROM "dead_letters"`, []string{"project", "pipeline", "handler", "block_number", "kind"},
+		[]any{"", "", "HandleEveryTwo", int64(2), string(utils.KindInterval)})
+	db.Reset()
+	if n, err := e.Replay(context.Background(), ""); err != nil || n != 1 {
+		t.Fatalf("Replay() = %d, %v, want 1 replayed", n, err)
+	}
+	if got, want := rec.take(), []string{"tick 1 of block 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replay got %v, want %v", got, want)
+	}
+	if states := db.Statements(`"interval_states"`); len(states) != 0 {
+		t.Errorf("replay ran %v on the interval states", states)
+	}
+	if deleted := db.Statements(`DELETE FROM "dead_letters"`); len(deleted) != 1 {
+		t.Errorf("replay deleted %d dead letters, want 1", len(deleted))
+	}
+}
+
+func TestExecutorTemplates(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Rows(`FROM "templates"`, []string{"name", "contract_address", "event_name", "pipeline", "block_number"},
+		[]any{"Pool", "0x01", "Transfer", "test-pipeline", int64(1)})
+	rec := &recorder{}
+	e, _ := newTestExecutor(t, testSetup{
+		rec: rec,
+		handlers: map[string]any{
+			"HandlePoolTransfer": func(log *ethereum.Log, deps *utils.Deps) (bool, error) {
+				rec.add("pool transfer %d/%d", log.BlockNumber, log.LogIndex)
+				return true, nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deps.Config.PipelineConfig.Name = "test-pipeline"
+			deps.Config.PipelineConfig.Templates = []configs.Template{{
+				Name:          "Pool",
+				EventHandlers: []configs.EventHandler{{Event: "Transfer(address indexed,address indexed,uint256)", Handler: "HandlePoolTransfer"}},
+			}}
+			deps.MetadataDB = db.DB
+		},
+	})
+	if err := e.ProcessBlock(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.take(), []string{"transfer 2/0", "pool transfer 2/2", "block 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only the addresses the pipeline saved are loaded.
+	queries := db.Statements(`FROM "templates"`)
+	if len(queries) == 0 {
+		t.Fatal("templates were not loaded")
+	}
+	if q := queries[0]; !strings.Contains(q.SQL, "pipeline = ") || !reflect.DeepEqual(q.Args[len(q.Args)-1], "test-pipeline") {
+		t.Errorf("templates loaded with %s %v, want them filtered by pipeline", q.SQL, q.Args)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("boom"), false},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "08006"}, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{Permanent(syscall.ECONNRESET), false},
+		{Retryable(errors.New("boom")), true},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := Transient(tt.err); got != tt.want {
+			t.Errorf("Transient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExecutorInitialize(t *testing.T) {
+	rec := &recorder{}
+	e, _ := newTestExecutor(t, testSetup{
+		rec: rec,
+		handlers: map[string]any{
+			"CreateTables": func(deps *utils.Deps) error {
+				rec.add("init")
+				return nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deps.Config.PipelineConfig.Initialization.InitializationHandlers = []string{"CreateTables"}
+		},
+	})
+	for i := 0; i < 2; i++ {
+		if err := e.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"init", "transfer 2/0", "block 2", "transfer 4/7", "block 4", "block 5"}
+	if got := rec.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := e.Initialize(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.take(); !reflect.DeepEqual(got, []string{"init"}) {
+		t.Errorf("forced Initialize got %v", got)
+	}
+}
+
+func TestExecutorBackfill(t *testing.T) {
+	reader := newTestReader()
+	for n := int64(6); n <= 250; n++ {
+		reader.blocks[n] = &ethereum.Block{Number: n}
+	}
+
+	var unordered atomic.Int64
+	checkpoints := &MemoryCheckpoints{}
+	e, rec := newTestExecutor(t, testSetup{
+		reader:      reader,
+		checkpoints: checkpoints,
+		handlers: map[string]any{
+			"HandleTransfer": func(log *ethereum.Log, deps *utils.Deps) (bool, error) {
+				unordered.Add(1000)
+				return true, nil
+			},
+			"CountBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+				unordered.Add(1)
+				return true, nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			p := &deps.Config.PipelineConfig
+			p.EventHandlers[0].OrderIndependent = true
+			p.BlockHandlers = append(p.BlockHandlers, configs.BlockHandler{Handler: "CountBlock", OrderIndependent: true})
+		},
+	})
+	if err := e.Backfill(context.Background(), 200, BackfillOptions{Workers: 4, ChunkSize: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks 2 and 4 to 200 are in scope.
+	want := []string{"block 2"}
+	for n := 4; n <= 200; n++ {
+		want = append(want, fmt.Sprintf("block %d", n))
+	}
+	if got := rec.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ordered handler ran on %v, want %v", got, want)
+	}
+	if got := unordered.Load(); got != 2000+int64(len(want)) {
+		t.Errorf("order independent handlers ran %d times, want %d", got, 2000+len(want))
+	}
+	if last, _, _ := checkpoints.Last(context.Background()); last != 200 {
+		t.Errorf("checkpoint = %d, want 200", last)
+	}
+}
+
+// reorgingReader is a memReader whose chain is replaced from block at - 1
+// on when block at is first read, as if the source reorganized while it was
+// being read.
+type reorgingReader struct {
+	*memReader
+	at   int64
+	once sync.Once
+}
+
+func (r *reorgingReader) Block(ctx context.Context, number int64) (*ethereum.Block, error) {
+	if number == r.at {
+		r.once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for n := r.at - 1; r.blocks[n] != nil; n++ {
+				parent := r.blocks[n-1].Hash
+				r.blocks[n] = &ethereum.Block{Number: n, Hash: fmt.Sprintf("0x%02xb", n), ParentHash: parent}
+			}
+		})
+	}
+	return r.memReader.Block(ctx, number)
+}
+
+func TestExecutorBackfillReorg(t *testing.T) {
+	reader := &reorgingReader{memReader: newTestReader(), at: 10}
+	for n := int64(1); n <= 25; n++ {
+		reader.blocks[n] = &ethereum.Block{Number: n, Hash: fmt.Sprintf("0x%02x", n), ParentHash: fmt.Sprintf("0x%02x", n-1)}
+	}
+	rec := &recorder{}
+	checkpoints := &MemoryCheckpoints{}
+	e, _ := newTestExecutor(t, testSetup{
+		reader:      reader,
+		checkpoints: checkpoints,
+		rec:         rec,
+		handlers: map[string]any{
+			"HandleBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+				rec.add("block %d %s", blockNumber, deps.Block.Hash)
+				return true, nil
+			},
+		},
+		configure: func(deps *utils.Deps) {
+			deps.Config.PipelineConfig.Source.Confirmations = 5
+		},
+	})
+	// One worker reads the blocks in order, so block 9 is read before the
+	// chain is replaced from it on.
+	if err := e.Backfill(context.Background(), 100, BackfillOptions{Workers: 1, ChunkSize: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks 20 to 25 are not confirmed yet.
+	if last, _, _ := checkpoints.Last(context.Background()); last != 20 {
+		t.Errorf("checkpoint = %d, want 20", last)
+	}
+	// Block 9 is processed again after the rollback to block 8.
+	var nines []string
+	for _, call := range rec.take() {
+		if strings.HasPrefix(call, "block 9 ") {
+			nines = append(nines, call)
+		}
+	}
+	if want := []string{"block 9 0x09", "block 9 0x09b"}; !reflect.DeepEqual(nines, want) {
+		t.Errorf("block 9 processed as %v, want %v", nines, want)
+	}
+}
+
+func TestExecutorTail(t *testing.T) {
+	reader := newTestReader()
+	checkpoints := &MemoryCheckpoints{}
+	e, rec := newTestExecutor(t, testSetup{
+		reader:      reader,
+		checkpoints: checkpoints,
+		configure: func(deps *utils.Deps) {
+			src := &deps.Config.PipelineConfig.Source
+			src.Confirmations = 2
+			// Only notifications wake Tail up within the test.
+			src.PollInterval = time.Hour
+			src.NotifyChannel = "blocks"
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- e.Tail(ctx) }()
+
+	waitFor := func(block int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if last, _, _ := checkpoints.Last(ctx); last >= block && e.Lag().Processed >= block {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("checkpoint did not reach block %d", block)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Head is 5: blocks up to 3 are confirmed, of which 2 is in scope.
+	waitFor(2)
+	reader.add(&ethereum.Block{Number: 6, Timestamp: time.Now()})
+	reader.add(&ethereum.Block{Number: 7, Timestamp: time.Now()})
+	waitFor(5)
+	if lag := e.Lag(); lag.Head != 7 || lag.Processed != 5 || lag.Blocks != 2 {
+		t.Errorf("Lag() = %+v", lag)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Tail() = %v, want context.Canceled", err)
+	}
+	if got, want := rec.take(), []string{"transfer 2/0", "block 2", "transfer 4/7", "block 4", "block 5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExecutorReorg(t *testing.T) {
+	rec := &recorder{}
+	reader := newTestReader()
+	checkpoints := &MemoryCheckpoints{}
+	e, _ := newTestExecutor(t, testSetup{
+		reader:      reader,
+		checkpoints: checkpoints,
+		rec:         rec,
+		handlers: map[string]any{
+			"HandleBlock": func(blockNumber int64, deps *utils.Deps) (bool, error) {
+				rec.add("block %d %s", blockNumber, deps.Block.Hash)
+				return true, nil
+			},
+		},
+	})
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec.take()
+
+	// Blocks 4 and 5 are replaced: the pipeline rolls back to block 2.
+	reader.add(&ethereum.Block{Number: 4, Hash: "0x04b", ParentHash: "0x03"})
+	reader.add(&ethereum.Block{Number: 5, Hash: "0x05b", ParentHash: "0x04b"})
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.take(), []string{"transfer 4/7", "block 4 0x04b", "block 5 0x05b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if last, _, _ := checkpoints.Last(context.Background()); last != 5 {
+		t.Errorf("checkpoint = %d, want 5", last)
+	}
+
+	// A block that does not extend the processed ones, while the source
+	// still has them, stops the pipeline.
+	reader.add(&ethereum.Block{Number: 6, Hash: "0x06", ParentHash: "0x05"})
+	var reorg *reorgError
+	if err := e.Run(context.Background()); !errors.As(err, &reorg) {
+		t.Errorf("Run() = %v, want a reorg error", err)
+	}
+
+	// No processed block is left on the chain.
+	for n := int64(2); n <= 6; n++ {
+		reader.add(&ethereum.Block{Number: n, Hash: fmt.Sprintf("0x%02xc", n)})
+	}
+	if err := e.Run(context.Background()); !errors.Is(err, ErrReorgTooDeep) {
+		t.Errorf("Run() = %v, want ErrReorgTooDeep", err)
+	}
+}
@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"context"
+	"time"
+
+	"github.com/Zettablock/zsource/dao/metadata"
+	"github.com/Zettablock/zsource/utils"
+)
+
+// Initialize runs the initialization handlers of the pipeline, in config
+// order. Each handler runs in its own transaction together with its record in
+// the initializations metadata table, and is skipped when already recorded,
+// so that it runs once per pipeline across restarts. force runs every handler
+// again, e.g. after the destination tables were rebuilt. Without a
+// MetadataDB, handlers run once per Executor.
+//
+// Run calls Initialize without force before the first block.
+func (e *Executor) Initialize(ctx context.Context, force bool) error {
+	cfg := e.deps.Config
+	done := map[string]bool{}
+	if e.deps.MetadataDB != nil && !force {
+		var rows []metadata.Initialization
+		err := e.deps.MetadataDB.WithContext(ctx).
+			Where("project = ? AND pipeline = ?", cfg.GetProjectName(), cfg.GetPipelineName()).
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			done[row.Handler] = true
+		}
+	} else if e.initialized && !force {
+		return nil
+	}
+
+	for _, name := range cfg.PipelineConfig.Initialization.InitializationHandlers {
+		if done[name] {
+			continue
+		}
+		handler, err := e.deps.Handlers.Initialization(name)
+		if err != nil {
+			return err
+		}
+		err = e.deps.Transaction(func(tx *utils.Deps) error {
+			if err := handler(tx); err != nil {
+				return &utils.HandlerError{Kind: utils.KindInitialization, Name: name, At: "pipeline " + cfg.GetPipelineName(), Err: err}
+			}
+			if tx.MetadataDB == nil {
+				return nil
+			}
+			return tx.MetadataDB.WithContext(ctx).Save(&metadata.Initialization{
+				Project:     cfg.GetProjectName(),
+				Pipeline:    cfg.GetPipelineName(),
+				Handler:     name,
+				CompletedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+		if e.deps.Logger != nil {
+			e.deps.Logger.Info("ran initialization handler", "handler", name)
+		}
+	}
+	e.initialized = true
+	return nil
+}
@@ -0,0 +1,175 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/evm"
+	"github.com/Zettablock/zsource/dao/metadata"
+	"github.com/Zettablock/zsource/destination"
+	"github.com/Zettablock/zsource/source"
+	"github.com/Zettablock/zsource/utils"
+
+	"gorm.io/gorm"
+)
+
+// ErrReorgTooDeep is returned when none of the processed blocks the pipeline
+// remembers, see configs.Source.ReorgDepth, is on the source chain anymore.
+// The pipeline cannot roll back by itself then.
+var ErrReorgTooDeep = errors.New("chain reorganization deeper than the reorg depth")
+
+// reorgError is returned by the commit step of a block whose parent is not
+// the last processed block.
+type reorgError struct {
+	block *ethereum.Block
+	last  metadata.ProcessedBlock
+}
+
+func (e *reorgError) Error() string {
+	return fmt.Sprintf("block %d: parent hash %s is not the hash %s of processed block %d",
+		e.block.Number, e.block.ParentHash, e.last.BlockHash, e.last.BlockNumber)
+}
+
+// processedBlocks returns the last processed blocks, newest first, up to
+// limit blocks or all of them if limit is -1. They are read from db, the
+// metadata db or transaction, or from memory if it is nil.
+func (e *Executor) processedBlocks(ctx context.Context, db *gorm.DB, limit int) ([]metadata.ProcessedBlock, error) {
+	if db == nil {
+		var blocks []metadata.ProcessedBlock
+		for i := len(e.chain) - 1; i >= 0 && (limit < 0 || len(blocks) < limit); i-- {
+			blocks = append(blocks, e.chain[i])
+		}
+		return blocks, nil
+	}
+	var blocks []metadata.ProcessedBlock
+	err := db.WithContext(ctx).
+		Where("project = ? AND pipeline = ?", e.deps.Config.GetProjectName(), e.deps.Config.GetPipelineName()).
+		Order("block_number DESC").
+		Limit(limit).
+		Find(&blocks).Error
+	return blocks, err
+}
+
+// recordBlock checks that block extends the chain of processed blocks and
+// records it, forgetting the blocks older than the reorg depth. It is part of
+// the commit step of every block.
+func (e *Executor) recordBlock(ctx context.Context, tx *utils.Deps, block *ethereum.Block) error {
+	last, err := e.processedBlocks(ctx, tx.MetadataDB, 1)
+	if err != nil {
+		return err
+	}
+	if len(last) == 1 && last[0].BlockNumber == block.Number-1 && block.ParentHash != "" &&
+		!strings.EqualFold(last[0].BlockHash, block.ParentHash) {
+		return &reorgError{block: block, last: last[0]}
+	}
+
+	processed := metadata.ProcessedBlock{
+		Project:     e.deps.Config.GetProjectName(),
+		Pipeline:    e.deps.Config.GetPipelineName(),
+		BlockNumber: block.Number,
+		BlockHash:   block.Hash,
+		ParentHash:  block.ParentHash,
+	}
+	depth := e.deps.Config.PipelineConfig.Source.GetReorgDepth()
+	if tx.MetadataDB == nil {
+		e.chain = append(e.chain, processed)
+		for len(e.chain) > 0 && e.chain[0].BlockNumber <= block.Number-depth {
+			e.chain = e.chain[1:]
+		}
+		return nil
+	}
+	db := tx.MetadataDB.WithContext(ctx)
+	if err := db.Save(&processed).Error; err != nil {
+		return err
+	}
+	return db.Where("project = ? AND pipeline = ? AND block_number <= ?", processed.Project, processed.Pipeline, block.Number-depth).
+		Delete(&metadata.ProcessedBlock{}).Error
+}
+
+// checkReorg compares the hashes of the processed blocks with the source
+// chain. If the last processed block was replaced, it rolls the pipeline back
+// to the newest processed block still on the chain, so that the blocks after
+// it are processed again, and reports true.
+func (e *Executor) checkReorg(ctx context.Context) (bool, error) {
+	processed, err := e.processedBlocks(ctx, e.deps.MetadataDB, -1)
+	if err != nil || len(processed) == 0 {
+		return false, err
+	}
+	for i, p := range processed {
+		block, err := e.reader.Block(ctx, p.BlockNumber)
+		if errors.Is(err, source.ErrBlockNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if strings.EqualFold(block.Hash, p.BlockHash) {
+			if i == 0 {
+				return false, nil
+			}
+			return true, e.rollback(ctx, block, processed[0].BlockNumber)
+		}
+	}
+	return false, fmt.Errorf("%w: none of the processed blocks %d to %d is on the source chain",
+		ErrReorgTooDeep, processed[len(processed)-1].BlockNumber, processed[0].BlockNumber)
+}
+
+// rollback undoes the blocks after ancestor, up to head, in one transaction:
+// it deletes their destination rows, see destination.DeleteAfter, the
+// template addresses, interval states and dead letters they recorded, and
+// moves the checkpoint back to ancestor. Deleting the interval states after
+// ancestor leaves the state each interval handler had at ancestor, see
+// utils.IntervalScheduler.
+func (e *Executor) rollback(ctx context.Context, ancestor *ethereum.Block, head int64) error {
+	p := e.deps.Config.PipelineConfig
+	project, pipeline := e.deps.Config.GetProjectName(), e.deps.Config.GetPipelineName()
+	var deleted int64
+	err := e.deps.Transaction(func(tx *utils.Deps) error {
+		if tx.DestinationDB != nil {
+			var err error
+			deleted, err = destination.DeleteAfter(tx.DestinationDB.WithContext(ctx), p.Destination.Schema, p.Destination.Entities, pipeline, ancestor.Number)
+			if err != nil {
+				return err
+			}
+		}
+		if tx.MetadataDB != nil {
+			db := tx.MetadataDB.WithContext(ctx)
+			if len(p.Templates) > 0 {
+				names := make([]string, 0, len(p.Templates))
+				for _, t := range p.Templates {
+					names = append(names, t.Name)
+				}
+				if err := db.Where("name IN ? AND pipeline = ? AND block_number > ?", names, pipeline, ancestor.Number).Delete(&evm.Template{}).Error; err != nil {
+					return err
+				}
+			}
+			for _, model := range []any{&metadata.IntervalState{}, &metadata.DeadLetter{}, &metadata.ProcessedBlock{}} {
+				err := db.Where("project = ? AND pipeline = ? AND block_number > ?", project, pipeline, ancestor.Number).Delete(model).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return e.checkpoints.Rewind(ctx, tx.MetadataDB, ancestor)
+	})
+	if err != nil {
+		return fmt.Errorf("roll back to block %d: %w", ancestor.Number, err)
+	}
+
+	if e.deps.MetadataDB == nil {
+		for len(e.chain) > 0 && e.chain[len(e.chain)-1].BlockNumber > ancestor.Number {
+			e.chain = e.chain[:len(e.chain)-1]
+		}
+	}
+	e.intervals.Reset()
+	for _, t := range p.Templates {
+		e.templates[t.Name] = addressSet(t.Addresses)
+	}
+	if e.deps.Logger != nil {
+		e.deps.Logger.Warn("rolled back chain reorganization", "ancestor", ancestor.Number, "head", head, "rows", deleted)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/metadata"
+	"github.com/Zettablock/zsource/utils"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// classifiedError overrides the classification of the error it wraps.
+type classifiedError struct {
+	err       error
+	transient bool
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as permanent: the block is not retried.
+func Permanent(err error) error {
+	return &classifiedError{err: err}
+}
+
+// Retryable marks err as transient: the block is retried.
+func Retryable(err error) error {
+	return &classifiedError{err: err, transient: true}
+}
+
+// Transient reports whether err is worth retrying: errors marked with
+// Retryable, timeouts, dropped connections, and Postgres serialization
+// failures, deadlocks and connection errors. Everything else, including
+// errors marked with Permanent, is permanent.
+func Transient(err error) bool {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.transient
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientSQLState(pgErr.Code)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientSQLState(string(pqErr.Code))
+	}
+	return false
+}
+
+// transientSQLState reports whether a Postgres error code is transient:
+// connection exceptions (class 08), serialization failures and deadlocks,
+// too many connections and server shutdowns.
+func transientSQLState(code string) bool {
+	switch code {
+	case "40001", "40P01", "53300", "57P01", "57P02", "57P03":
+		return true
+	}
+	return strings.HasPrefix(code, "08")
+}
+
+// processWithRetry processes block number, retrying transient failures with
+// the backoff of the failing handler's retry policy. Once attempts are
+// exhausted, or right away for permanent errors, the error is returned unless
+// the policy dead-letters: the block is then processed again without the
+// failing handler, which is recorded in the dead_letters table in the
+// transaction of that block. Failures that are not handler errors are never
+// dead-lettered.
+//
+// data, when not nil, is the prefetched source data of the block.
+func (e *Executor) processWithRetry(ctx context.Context, number int64, data *blockData, sel selection, commit func(tx *utils.Deps, block *ethereum.Block) error) error {
+	sel.skip = map[string]bool{}
+	attempts := map[string]int{}
+	var letters []metadata.DeadLetter
+	for {
+		var err error
+		if data == nil {
+			data, err = e.fetch(ctx, number)
+		}
+		if err == nil {
+			err = e.apply(ctx, data, sel, saveDeadLetters(ctx, letters, commit))
+		}
+		if err == nil {
+			if e.deps.Logger != nil {
+				for _, letter := range letters {
+					e.deps.Logger.Error("dead-lettered block", "block", number, "handler", letter.Handler, "attempts", letter.Attempts, "error", letter.Error)
+				}
+			}
+			return nil
+		}
+
+		var handlerErr *utils.HandlerError
+		name := ""
+		if errors.As(err, &handlerErr) {
+			name = handlerErr.Name
+		}
+		policy := e.deps.Config.RetryPolicy(name)
+		attempts[name]++
+		if Transient(err) && attempts[name] < policy.Attempts() {
+			backoff := policy.Backoff(attempts[name])
+			if e.deps.Logger != nil {
+				e.deps.Logger.Warn("retrying block", "block", number, "attempt", attempts[name], "backoff", backoff, "error", err)
+			}
+			if err := sleep(ctx, backoff); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if handlerErr == nil || !policy.DeadLetters() {
+			return err
+		}
+		letter, err := e.deadLetter(handlerErr, number, attempts[name])
+		if err != nil {
+			return err
+		}
+		letters = append(letters, letter)
+		sel.skip[name] = true
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
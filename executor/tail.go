@@ -0,0 +1,140 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Zettablock/zsource/source"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// Lag is how far a pipeline is behind the head of its source.
+type Lag struct {
+	// Head is the latest source block.
+	Head int64
+	// Processed is the checkpoint, the last processed block.
+	Processed int64
+	// Blocks is Head - Processed.
+	Blocks int64
+	// Time is the age of the last processed block.
+	Time time.Duration
+}
+
+// lagState holds the last lag measured by Tail.
+type lagState struct {
+	mu  sync.Mutex
+	lag Lag
+}
+
+// Lag returns the lag measured by Tail after its last round.
+func (e *Executor) Lag() Lag {
+	e.lag.mu.Lock()
+	defer e.lag.mu.Unlock()
+	return e.lag.lag
+}
+
+// Tail follows the head of the source: it processes the blocks in scope after
+// the checkpoint that are at least Source.Confirmations blocks behind the
+// latest block, then waits for new blocks, every Source.PollInterval or on
+// notifications on Source.NotifyChannel when the reader supports them. It
+// returns when ctx is done, or nil once past the last block range.
+func (e *Executor) Tail(ctx context.Context) error {
+	if !e.initialized {
+		if err := e.Initialize(ctx, false); err != nil {
+			return err
+		}
+	}
+	s := e.deps.Config.PipelineConfig.Source
+	interval := s.PollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	notifications := e.notifications(ctx, s.NotifyChannel)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		case _, ok := <-notifications:
+			if !ok {
+				if e.deps.Logger != nil {
+					e.deps.Logger.Warn("notifications stopped, polling", "channel", s.NotifyChannel)
+				}
+				notifications = nil
+			}
+		}
+
+		head, err := e.reader.LatestBlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+		finished, err := e.runTo(ctx, head-s.Confirmations)
+		if err != nil {
+			return err
+		}
+		if err := e.measureLag(ctx, head); err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// notifications subscribes to channel if the reader supports it, and returns
+// nil otherwise so that Tail polls.
+func (e *Executor) notifications(ctx context.Context, channel string) <-chan struct{} {
+	if channel == "" {
+		return nil
+	}
+	notifier, ok := e.reader.(source.Notifier)
+	if !ok {
+		return nil
+	}
+	notifications, err := notifier.Notifications(ctx, channel)
+	if err != nil {
+		if e.deps.Logger != nil {
+			e.deps.Logger.Warn("cannot listen for new blocks, polling", "channel", channel, "error", err)
+		}
+		return nil
+	}
+	return notifications
+}
+
+func (e *Executor) measureLag(ctx context.Context, head int64) error {
+	lag := Lag{Head: head}
+	last, ok, err := e.checkpoints.Last(ctx)
+	if err != nil {
+		return err
+	}
+	if ok {
+		lag.Processed = last
+		block, err := e.reader.Block(ctx, last)
+		if err != nil {
+			return err
+		}
+		lag.Time = time.Since(block.Timestamp)
+	}
+	lag.Blocks = head - lag.Processed
+
+	e.lag.mu.Lock()
+	e.lag.lag = lag
+	e.lag.mu.Unlock()
+	if e.deps.Logger != nil {
+		e.deps.Logger.Info("pipeline lag", "head", lag.Head, "processed", lag.Processed, "blocks", lag.Blocks, "seconds", lag.Time.Seconds())
+	}
+	return nil
+}
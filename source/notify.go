@@ -0,0 +1,70 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var ErrNotificationsUnsupported = errors.New("notifications not supported")
+
+// Notifier is implemented by readers that can signal new blocks instead of
+// being polled. The returned channel receives a value, coalesced, whenever
+// new blocks may be available, and is closed when notifications stop, e.g.
+// because the connection was lost or ctx is done.
+type Notifier interface {
+	Notifications(ctx context.Context, channel string) (<-chan struct{}, error)
+}
+
+var _ Notifier = (*DBReader)(nil)
+
+// Notifications listens on a Postgres notification channel of the source db,
+// on a connection of its own. It needs the db to use the pgx driver, and
+// returns ErrNotificationsUnsupported otherwise.
+func (r *DBReader) Notifications(ctx context.Context, channel string) (<-chan struct{}, error) {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("DBReader: listen %s: %w", channel, err)
+	}
+
+	notifications := make(chan struct{}, 1)
+	listening := make(chan error, 1)
+	go func() {
+		defer close(notifications)
+		defer conn.Close()
+		err := conn.Raw(func(driverConn any) error {
+			c, ok := driverConn.(*stdlib.Conn)
+			if !ok {
+				return fmt.Errorf("DBReader: %T driver: %w", driverConn, ErrNotificationsUnsupported)
+			}
+			if _, err := c.Conn().Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return fmt.Errorf("DBReader: listen %s: %w", channel, err)
+			}
+			listening <- nil
+			for {
+				if _, err := c.Conn().WaitForNotification(ctx); err != nil {
+					return err
+				}
+				select {
+				case notifications <- struct{}{}:
+				default:
+				}
+			}
+		})
+		select {
+		case listening <- err:
+		default:
+		}
+	}()
+	if err := <-listening; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
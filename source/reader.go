@@ -0,0 +1,165 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+
+	"gorm.io/gorm"
+)
+
+// Reader reads the chain data a pipeline processes, one block at a time.
+// Block returns ErrBlockNotFound for blocks the source does not have yet.
+type Reader interface {
+	LatestBlockNumber(ctx context.Context) (int64, error)
+	Block(ctx context.Context, number int64) (*ethereum.Block, error)
+	Transactions(ctx context.Context, number int64) ([]*ethereum.Transaction, error)
+	Logs(ctx context.Context, number int64) ([]*ethereum.Log, error)
+	Traces(ctx context.Context, number int64) ([]*ethereum.Trace, error)
+}
+
+// LogFilterer is implemented by readers that select logs in the source, so
+// that the logs no handler listens to are never read. FilterLogs returns the
+// logs of block number that match any of queries, in log index order.
+type LogFilterer interface {
+	FilterLogs(ctx context.Context, number int64, queries []LogQuery) ([]*ethereum.Log, error)
+}
+
+// BlockFetcher is implemented by readers that fetch the whole data of a
+// block at once, such as RPCReader, whose Transactions, Logs and Traces each
+// fetch the block again. Callers needing more than the block header should
+// call BlockData once per block instead.
+type BlockFetcher interface {
+	BlockData(ctx context.Context, number int64, withTraces bool) (*BlockData, error)
+}
+
+// LogQuery selects the logs of the contracts in Addresses, or of any contract
+// when it is empty, whose topics match Topics.
+type LogQuery struct {
+	Addresses []string
+	Topics    configs.TopicFilter
+}
+
+// Where returns a SQL condition and its arguments matching the query against
+// the contract_address and topics columns of a logs table. Addresses are
+// compared case-insensitively.
+func (q LogQuery) Where() (string, []any) {
+	where, args := q.Topics.Where()
+	if len(q.Addresses) == 0 {
+		return where, args
+	}
+	addresses := make([]string, len(q.Addresses))
+	for i, address := range q.Addresses {
+		addresses[i] = strings.ToLower(address)
+	}
+	return "contract_address IN ? AND " + where, append([]any{addresses}, args...)
+}
+
+var (
+	_ Reader = (*RPCReader)(nil)
+	_ Reader = (*DBReader)(nil)
+
+	_ LogFilterer  = (*DBReader)(nil)
+	_ BlockFetcher = (*RPCReader)(nil)
+)
+
+// DBReader reads blocks, transactions, logs and traces from the tables of a
+// source db schema.
+type DBReader struct {
+	db     *gorm.DB
+	schema string
+}
+
+func NewDBReader(db *gorm.DB, schema string) *DBReader {
+	return &DBReader{db: db, schema: schema}
+}
+
+// LatestBlockNumber returns the highest block number in the blocks table.
+func (r *DBReader) LatestBlockNumber(ctx context.Context) (int64, error) {
+	var number *int64
+	err := r.db.WithContext(ctx).Table(r.schema + ".blocks").Select("MAX(number)").Scan(&number).Error
+	if err != nil {
+		return 0, fmt.Errorf("DBReader: latest block: %w", err)
+	}
+	if number == nil {
+		return 0, fmt.Errorf("DBReader: %s.blocks is empty: %w", r.schema, ErrBlockNotFound)
+	}
+	return *number, nil
+}
+
+func (r *DBReader) Block(ctx context.Context, number int64) (*ethereum.Block, error) {
+	var block ethereum.Block
+	err := r.db.WithContext(ctx).Table(r.schema+".blocks").Where("number = ?", number).Take(&block).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("DBReader: block %d: %w", number, ErrBlockNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DBReader: block %d: %w", number, err)
+	}
+	return &block, nil
+}
+
+func (r *DBReader) Transactions(ctx context.Context, number int64) ([]*ethereum.Transaction, error) {
+	var txs []*ethereum.Transaction
+	err := r.db.WithContext(ctx).Table(r.schema+".transactions").
+		Where("block_number = ?", number).
+		Order("transaction_index").
+		Find(&txs).Error
+	if err != nil {
+		return nil, fmt.Errorf("DBReader: transactions of block %d: %w", number, err)
+	}
+	return txs, nil
+}
+
+func (r *DBReader) Logs(ctx context.Context, number int64) ([]*ethereum.Log, error) {
+	var logs []*ethereum.Log
+	err := r.db.WithContext(ctx).Table(r.schema+".logs").
+		Where("block_number = ?", number).
+		Order("log_index").
+		Find(&logs).Error
+	if err != nil {
+		return nil, fmt.Errorf("DBReader: logs of block %d: %w", number, err)
+	}
+	return logs, nil
+}
+
+// FilterLogs reads the logs of block number matching any of queries, with
+// the queries applied by Postgres.
+func (r *DBReader) FilterLogs(ctx context.Context, number int64, queries []LogQuery) ([]*ethereum.Log, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	conds := make([]string, 0, len(queries))
+	var args []any
+	for _, q := range queries {
+		where, whereArgs := q.Where()
+		conds = append(conds, "("+where+")")
+		args = append(args, whereArgs...)
+	}
+	var logs []*ethereum.Log
+	err := r.db.WithContext(ctx).Table(r.schema+".logs").
+		Where("block_number = ?", number).
+		Where(strings.Join(conds, " OR "), args...).
+		Order("log_index").
+		Find(&logs).Error
+	if err != nil {
+		return nil, fmt.Errorf("DBReader: logs of block %d: %w", number, err)
+	}
+	return logs, nil
+}
+
+func (r *DBReader) Traces(ctx context.Context, number int64) ([]*ethereum.Trace, error) {
+	var traces []*ethereum.Trace
+	err := r.db.WithContext(ctx).Table(r.schema+".traces").
+		Where("block_number = ?", number).
+		Order("transaction_index, trace_index").
+		Find(&traces).Error
+	if err != nil {
+		return nil, fmt.Errorf("DBReader: traces of block %d: %w", number, err)
+	}
+	return traces, nil
+}
@@ -0,0 +1,47 @@
+package source
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+)
+
+func TestDBReaderFilterLogs(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewDBReader(db.DB, "ethereum")
+	transfer := "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	queries := []LogQuery{
+		{Addresses: []string{"0x00000000000000000000000000000000000000AA"}, Topics: configs.TopicFilter{{transfer}, nil, {"0x01"}}},
+		{Topics: configs.TopicFilter{{transfer}}},
+	}
+	if _, err := r.FilterLogs(context.Background(), 7, queries); err != nil {
+		t.Fatal(err)
+	}
+	statements := db.Statements(`"ethereum"."logs"`)
+	if len(statements) != 1 {
+		t.Fatalf("FilterLogs() ran %d queries, want 1", len(statements))
+	}
+	sql := statements[0].SQL
+	for _, want := range []string{"block_number = $1 AND ((", "contract_address IN ($2)", "topics[1] IN ($3)", "topics[3] IN ($4)", " OR ", "topics[1] IN ($5)"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("query %s has no %s", sql, want)
+		}
+	}
+	wantArgs := []any{int64(7), "0x00000000000000000000000000000000000000aa", transfer, "0x01", transfer}
+	if !reflect.DeepEqual(statements[0].Args, wantArgs) {
+		t.Errorf("query args = %v, want %v", statements[0].Args, wantArgs)
+	}
+
+	// Without queries no handler needs logs.
+	db.Reset()
+	if logs, err := r.FilterLogs(context.Background(), 7, nil); err != nil || logs != nil || len(db.Statements("")) != 0 {
+		t.Errorf("FilterLogs(nil) = %v, %v and ran %v", logs, err, db.Statements(""))
+	}
+}
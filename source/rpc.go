@@ -0,0 +1,494 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Zettablock/zsource/dao/ethereum"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// RPCReader reads blocks, transactions, logs and traces from an Ethereum
+// JSON-RPC endpoint and converts them into the same models the source db
+// tables are mapped to. Traces are read with the trace_block method, so the
+// endpoint must expose the trace namespace (Erigon, Nethermind, Reth, ...).
+type RPCReader struct {
+	client *rpc.Client
+}
+
+// NewRPCReader connects to the JSON-RPC endpoint at url.
+func NewRPCReader(ctx context.Context, url string) (*RPCReader, error) {
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("RPCReader: dial %s: %w", url, err)
+	}
+	return &RPCReader{client: client}, nil
+}
+
+func (r *RPCReader) Close() {
+	r.client.Close()
+}
+
+// LatestBlockNumber returns the number of the most recent block.
+func (r *RPCReader) LatestBlockNumber(ctx context.Context) (int64, error) {
+	var number hexutil.Uint64
+	if err := r.client.CallContext(ctx, &number, "eth_blockNumber"); err != nil {
+		return 0, fmt.Errorf("RPCReader: eth_blockNumber: %w", err)
+	}
+	return int64(number), nil
+}
+
+// Block returns the block header of the given number.
+func (r *RPCReader) Block(ctx context.Context, number int64) (*ethereum.Block, error) {
+	block, err := r.getBlock(ctx, number, false)
+	if err != nil {
+		return nil, err
+	}
+	return block.toBlock(), nil
+}
+
+// BlockData is everything the reader fetches for one block.
+type BlockData struct {
+	Block        *ethereum.Block
+	Transactions []*ethereum.Transaction
+	Logs         []*ethereum.Log
+	Traces       []*ethereum.Trace
+}
+
+// BlockData fetches the block with its transactions, receipts and logs. Traces
+// are only fetched when withTraces is set since not every endpoint serves
+// them.
+func (r *RPCReader) BlockData(ctx context.Context, number int64, withTraces bool) (*BlockData, error) {
+	raw, err := r.getBlock(ctx, number, true)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := r.getReceipts(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &BlockData{Block: raw.toBlock()}
+	blockTime := data.Block.Timestamp
+	status := make(map[string]int32, len(receipts))
+	for i, tx := range raw.Transactions {
+		receipt := receipts[i]
+		data.Transactions = append(data.Transactions, tx.toTransaction(receipt, blockTime))
+		status[strings.ToLower(tx.Hash)] = int32(receipt.Status)
+		for _, l := range receipt.Logs {
+			data.Logs = append(data.Logs, l.toLog(blockTime))
+		}
+	}
+
+	if withTraces {
+		traces, err := r.getTraces(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		for i, t := range traces {
+			data.Traces = append(data.Traces, t.toTrace(i, blockTime, status))
+		}
+	}
+	return data, nil
+}
+
+// Transactions returns the transactions of a block, completed with their
+// receipts. It, Logs and Traces each fetch the block with BlockData; call
+// BlockData to read several of them.
+func (r *RPCReader) Transactions(ctx context.Context, number int64) ([]*ethereum.Transaction, error) {
+	data, err := r.BlockData(ctx, number, false)
+	if err != nil {
+		return nil, err
+	}
+	return data.Transactions, nil
+}
+
+// Logs returns the logs emitted in a block, in log index order.
+func (r *RPCReader) Logs(ctx context.Context, number int64) ([]*ethereum.Log, error) {
+	data, err := r.BlockData(ctx, number, false)
+	if err != nil {
+		return nil, err
+	}
+	return data.Logs, nil
+}
+
+// Traces returns the parity-style traces of a block.
+func (r *RPCReader) Traces(ctx context.Context, number int64) ([]*ethereum.Trace, error) {
+	data, err := r.BlockData(ctx, number, true)
+	if err != nil {
+		return nil, err
+	}
+	return data.Traces, nil
+}
+
+func (r *RPCReader) getBlock(ctx context.Context, number int64, fullTxs bool) (*rpcBlock, error) {
+	var block *rpcBlock
+	if err := r.client.CallContext(ctx, &block, "eth_getBlockByNumber", hexutil.EncodeUint64(uint64(number)), fullTxs); err != nil {
+		return nil, fmt.Errorf("RPCReader: eth_getBlockByNumber %d: %w", number, err)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("RPCReader: block %d: %w", number, ErrBlockNotFound)
+	}
+	return block, nil
+}
+
+// getReceipts fetches the receipts of all transactions of block, in
+// transaction order. eth_getBlockReceipts is tried first and the reader falls
+// back to one eth_getTransactionReceipt per transaction for endpoints that
+// don't support it.
+func (r *RPCReader) getReceipts(ctx context.Context, block *rpcBlock) ([]*rpcReceipt, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil
+	}
+	var receipts []*rpcReceipt
+	err := r.client.CallContext(ctx, &receipts, "eth_getBlockReceipts", hexutil.EncodeUint64(uint64(block.Number)))
+	if err == nil && len(receipts) == len(block.Transactions) {
+		return receipts, nil
+	}
+	var rpcErr rpc.Error
+	if err != nil && !errors.As(err, &rpcErr) {
+		return nil, fmt.Errorf("RPCReader: eth_getBlockReceipts %d: %w", block.Number, err)
+	}
+
+	batch := make([]rpc.BatchElem, len(block.Transactions))
+	receipts = make([]*rpcReceipt, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Args:   []any{tx.Hash},
+			Result: &receipts[i],
+		}
+	}
+	if err := r.client.BatchCallContext(ctx, batch); err != nil {
+		return nil, fmt.Errorf("RPCReader: eth_getTransactionReceipt block %d: %w", block.Number, err)
+	}
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, fmt.Errorf("RPCReader: eth_getTransactionReceipt %s: %w", block.Transactions[i].Hash, elem.Error)
+		}
+		if receipts[i] == nil {
+			return nil, fmt.Errorf("RPCReader: receipt of %s not found", block.Transactions[i].Hash)
+		}
+	}
+	return receipts, nil
+}
+
+func (r *RPCReader) getTraces(ctx context.Context, number int64) ([]*rpcTrace, error) {
+	var traces []*rpcTrace
+	if err := r.client.CallContext(ctx, &traces, "trace_block", hexutil.EncodeUint64(uint64(number))); err != nil {
+		return nil, fmt.Errorf("RPCReader: trace_block %d: %w", number, err)
+	}
+	return traces, nil
+}
+
+type rpcBlock struct {
+	Number           hexutil.Uint64    `json:"number"`
+	Hash             string            `json:"hash"`
+	ParentHash       string            `json:"parentHash"`
+	Nonce            string            `json:"nonce"`
+	MixHash          string            `json:"mixHash"`
+	Sha3Uncles       string            `json:"sha3Uncles"`
+	LogsBloom        string            `json:"logsBloom"`
+	TransactionsRoot string            `json:"transactionsRoot"`
+	StateRoot        string            `json:"stateRoot"`
+	ReceiptsRoot     string            `json:"receiptsRoot"`
+	Miner            string            `json:"miner"`
+	Difficulty       *hexutil.Big      `json:"difficulty"`
+	TotalDifficulty  *hexutil.Big      `json:"totalDifficulty"`
+	Size             hexutil.Uint64    `json:"size"`
+	GasLimit         hexutil.Uint64    `json:"gasLimit"`
+	GasUsed          hexutil.Uint64    `json:"gasUsed"`
+	BaseFeePerGas    *hexutil.Big      `json:"baseFeePerGas"`
+	Timestamp        hexutil.Uint64    `json:"timestamp"`
+	Uncles           []string          `json:"uncles"`
+	ExtraData        hexutil.Bytes     `json:"extraData"`
+	Transactions     []*rpcTransaction `json:"transactions"`
+}
+
+// UnmarshalJSON accepts both hash-only and full transaction lists; hash-only
+// entries only fill in the transaction hash.
+func (b *rpcBlock) UnmarshalJSON(data []byte) error {
+	type block rpcBlock
+	var raw struct {
+		block
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*b = rpcBlock(raw.block)
+	b.Transactions = make([]*rpcTransaction, 0, len(raw.Transactions))
+	for _, msg := range raw.Transactions {
+		tx := &rpcTransaction{}
+		if len(msg) > 0 && msg[0] == '"' {
+			if err := json.Unmarshal(msg, &tx.Hash); err != nil {
+				return err
+			}
+		} else if err := json.Unmarshal(msg, tx); err != nil {
+			return err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+	return nil
+}
+
+func (b *rpcBlock) toBlock() *ethereum.Block {
+	timestamp := time.Unix(int64(b.Timestamp), 0).UTC()
+	return &ethereum.Block{
+		Number:            int64(b.Number),
+		Hash:              b.Hash,
+		ParentHash:        b.ParentHash,
+		Nonce:             b.Nonce,
+		MixHash:           b.MixHash,
+		Sha3Uncles:        b.Sha3Uncles,
+		LogsBloom:         b.LogsBloom,
+		TransactionsRoot:  b.TransactionsRoot,
+		StateRoot:         b.StateRoot,
+		ReceiptsRoot:      b.ReceiptsRoot,
+		Miner:             strings.ToLower(b.Miner),
+		Difficulty:        bigToFloat(b.Difficulty),
+		TotalDifficulty:   bigToFloat(b.TotalDifficulty),
+		Size:              int64(b.Size),
+		GasLimit:          int64(b.GasLimit),
+		GasUsed:           int64(b.GasUsed),
+		BaseFeePerGas:     bigToInt64(b.BaseFeePerGas),
+		Timestamp:         timestamp,
+		Uncles:            b.Uncles,
+		NumOfTransactions: int32(len(b.Transactions)),
+		ExtraDataRaw:      b.ExtraData.String(),
+		ExtraData:         printable(b.ExtraData),
+		ProcessTime:       time.Now().UTC(),
+		BlockDate:         blockDate(timestamp),
+	}
+}
+
+type rpcTransaction struct {
+	Hash                 string          `json:"hash"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	BlockHash            string          `json:"blockHash"`
+	BlockNumber          hexutil.Uint64  `json:"blockNumber"`
+	TransactionIndex     hexutil.Uint64  `json:"transactionIndex"`
+	From                 string          `json:"from"`
+	To                   *string         `json:"to"`
+	Value                *hexutil.Big    `json:"value"`
+	Type                 hexutil.Uint64  `json:"type"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	Input                string          `json:"input"`
+	V                    string          `json:"v"`
+	R                    string          `json:"r"`
+	S                    string          `json:"s"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+	AccessList           json.RawMessage `json:"accessList"`
+}
+
+func (t *rpcTransaction) toTransaction(receipt *rpcReceipt, blockTime time.Time) *ethereum.Transaction {
+	tx := &ethereum.Transaction{
+		Hash:                   strings.ToLower(t.Hash),
+		Nonce:                  int64(t.Nonce),
+		BlockHash:              t.BlockHash,
+		BlockNumber:            int64(t.BlockNumber),
+		TransactionIndex:       int32(t.TransactionIndex),
+		FromAddress:            strings.ToLower(t.From),
+		Value:                  bigToFloat(t.Value),
+		Type:                   strconv.FormatUint(uint64(t.Type), 10),
+		GasPrice:               bigToFloat(t.GasPrice),
+		Input:                  t.Input,
+		V:                      t.V,
+		S:                      t.S,
+		R:                      t.R,
+		MaxFeePerGas:           bigToFloat(t.MaxFeePerGas),
+		MaxPriorityFeePerGas:   bigToFloat(t.MaxPriorityFeePerGas),
+		GasLimit:               int64(t.Gas),
+		BlockTime:              blockTime,
+		Status:                 int32(receipt.Status),
+		GasUsed:                int64(receipt.GasUsed),
+		CumulativeGasUsed:      int64(receipt.CumulativeGasUsed),
+		EffectiveGasPrice:      bigToFloat(receipt.EffectiveGasPrice),
+		ReceiptContractAddress: strings.ToLower(receipt.ContractAddress),
+		ProcessTime:            time.Now().UTC(),
+		BlockDate:              blockDate(blockTime),
+	}
+	if t.To != nil {
+		tx.ToAddress = strings.ToLower(*t.To)
+	}
+	if t.ChainID != nil {
+		tx.ChainID = t.ChainID.ToInt().String()
+	}
+	if len(t.AccessList) > 0 && string(t.AccessList) != "null" {
+		tx.AccessList = string(t.AccessList)
+	}
+	return tx
+}
+
+type rpcReceipt struct {
+	TransactionHash   string         `json:"transactionHash"`
+	Status            hexutil.Uint64 `json:"status"`
+	GasUsed           hexutil.Uint64 `json:"gasUsed"`
+	CumulativeGasUsed hexutil.Uint64 `json:"cumulativeGasUsed"`
+	EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice"`
+	ContractAddress   string         `json:"contractAddress"`
+	Logs              []*rpcLog      `json:"logs"`
+}
+
+type rpcLog struct {
+	Address          string         `json:"address"`
+	Topics           []string       `json:"topics"`
+	Data             string         `json:"data"`
+	BlockNumber      hexutil.Uint64 `json:"blockNumber"`
+	BlockHash        string         `json:"blockHash"`
+	TransactionHash  string         `json:"transactionHash"`
+	TransactionIndex hexutil.Uint64 `json:"transactionIndex"`
+	LogIndex         hexutil.Uint64 `json:"logIndex"`
+	Removed          bool           `json:"removed"`
+}
+
+func (l *rpcLog) toLog(blockTime time.Time) *ethereum.Log {
+	return &ethereum.Log{
+		TransactionHash:  strings.ToLower(l.TransactionHash),
+		TransactionIndex: int32(l.TransactionIndex),
+		BlockNumber:      int64(l.BlockNumber),
+		BlockHash:        l.BlockHash,
+		Removed:          l.Removed,
+		LogIndex:         int32(l.LogIndex),
+		Data:             l.Data,
+		Topics:           l.Topics,
+		ContractAddress:  strings.ToLower(l.Address),
+		BlockTime:        blockTime,
+		ProcessTime:      time.Now().UTC(),
+		BlockDate:        blockDate(blockTime),
+	}
+}
+
+type rpcTrace struct {
+	Action struct {
+		CallType      string         `json:"callType"`
+		From          string         `json:"from"`
+		To            string         `json:"to"`
+		Gas           hexutil.Uint64 `json:"gas"`
+		Input         string         `json:"input"`
+		Init          string         `json:"init"`
+		Value         *hexutil.Big   `json:"value"`
+		Address       string         `json:"address"`
+		RefundAddress string         `json:"refundAddress"`
+		Balance       *hexutil.Big   `json:"balance"`
+		Author        string         `json:"author"`
+		RewardType    string         `json:"rewardType"`
+	} `json:"action"`
+	Result *struct {
+		GasUsed hexutil.Uint64 `json:"gasUsed"`
+		Output  string         `json:"output"`
+		Address string         `json:"address"`
+		Code    string         `json:"code"`
+	} `json:"result"`
+	BlockHash           string  `json:"blockHash"`
+	BlockNumber         uint64  `json:"blockNumber"`
+	Subtraces           int64   `json:"subtraces"`
+	TraceAddress        []int   `json:"traceAddress"`
+	TransactionHash     *string `json:"transactionHash"`
+	TransactionPosition *int32  `json:"transactionPosition"`
+	Type                string  `json:"type"`
+	Error               string  `json:"error"`
+}
+
+// toTrace converts a parity-style trace. index is the position of the trace in
+// the block and status maps transaction hashes to their receipt status.
+func (t *rpcTrace) toTrace(index int, blockTime time.Time, status map[string]int32) *ethereum.Trace {
+	a := t.Action
+	trace := &ethereum.Trace{
+		BlockNumber:  int64(t.BlockNumber),
+		BlockHash:    t.BlockHash,
+		BlockTime:    blockTime,
+		FromAddress:  strings.ToLower(a.From),
+		ToAddress:    strings.ToLower(a.To),
+		Value:        bigToFloat(a.Value),
+		Input:        a.Input,
+		TraceType:    t.Type,
+		CallType:     a.CallType,
+		RewardType:   a.RewardType,
+		Gas:          float64(a.Gas),
+		Subtraces:    t.Subtraces,
+		TraceAddress: make([]string, 0, len(t.TraceAddress)),
+		Error:        t.Error,
+		TraceIndex:   int32(index),
+		ProcessTime:  time.Now().UTC(),
+		BlockDate:    blockDate(blockTime),
+	}
+	if t.Error == "" {
+		trace.Status = 1
+	}
+	for _, i := range t.TraceAddress {
+		trace.TraceAddress = append(trace.TraceAddress, strconv.Itoa(i))
+	}
+	if t.Result != nil {
+		trace.GasUsed = int64(t.Result.GasUsed)
+		trace.Output = t.Result.Output
+	}
+	switch t.Type {
+	case "create":
+		trace.Input = a.Init
+		if t.Result != nil {
+			trace.ToAddress = strings.ToLower(t.Result.Address)
+			trace.Output = t.Result.Code
+		}
+	case "suicide":
+		trace.FromAddress = strings.ToLower(a.Address)
+		trace.ToAddress = strings.ToLower(a.RefundAddress)
+		trace.Value = bigToFloat(a.Balance)
+	case "reward":
+		trace.ToAddress = strings.ToLower(a.Author)
+	}
+	if t.TransactionHash != nil {
+		trace.TransactionHash = strings.ToLower(*t.TransactionHash)
+		trace.TransactionStatus = status[trace.TransactionHash]
+		trace.TraceID = fmt.Sprintf("%s_%s_%s", t.Type, trace.TransactionHash, strings.Join(trace.TraceAddress, "_"))
+	} else {
+		trace.TraceID = fmt.Sprintf("%s_%d_%d", t.Type, t.BlockNumber, index)
+	}
+	if t.TransactionPosition != nil {
+		trace.TransactionIndex = *t.TransactionPosition
+	}
+	return trace
+}
+
+func bigToFloat(b *hexutil.Big) float64 {
+	if b == nil {
+		return 0
+	}
+	f, _ := new(big.Float).SetInt(b.ToInt()).Float64()
+	return f
+}
+
+func bigToInt64(b *hexutil.Big) int64 {
+	if b == nil {
+		return 0
+	}
+	return b.ToInt().Int64()
+}
+
+func blockDate(t time.Time) time.Time {
+	return t.Truncate(24 * time.Hour)
+}
+
+// printable keeps the printable characters of a block's extra data.
+func printable(b []byte) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, strings.ToValidUTF8(string(b), ""))
+}
@@ -0,0 +1,223 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testTxHash   = "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060"
+	testBlockHex = "0xa"
+)
+
+var testBlock = map[string]any{
+	"number":           testBlockHex,
+	"hash":             "0x01",
+	"parentHash":       "0x00",
+	"nonce":            "0x0000000000000000",
+	"mixHash":          "0x02",
+	"sha3Uncles":       "0x03",
+	"logsBloom":        "0x",
+	"transactionsRoot": "0x04",
+	"stateRoot":        "0x05",
+	"receiptsRoot":     "0x06",
+	"miner":            "0xAB00000000000000000000000000000000000001",
+	"difficulty":       "0x0",
+	"size":             "0x220",
+	"gasLimit":         "0x1c9c380",
+	"gasUsed":          "0x5208",
+	"baseFeePerGas":    "0x7",
+	"timestamp":        "0x661e5288",
+	"uncles":           []string{},
+	"extraData":        "0x6265617665726275696c642e6f7267",
+	"transactions": []any{map[string]any{
+		"hash":             testTxHash,
+		"nonce":            "0x1",
+		"blockHash":        "0x01",
+		"blockNumber":      testBlockHex,
+		"transactionIndex": "0x0",
+		"from":             "0x00000000000000000000000000000000000000a1",
+		"to":               "0x00000000000000000000000000000000000000b2",
+		"value":            "0xde0b6b3a7640000",
+		"type":             "0x2",
+		"gasPrice":         "0x3b9aca00",
+		"gas":              "0x5208",
+		"input":            "0x",
+		"v":                "0x1",
+		"r":                "0x10",
+		"s":                "0x11",
+		"chainId":          "0x1",
+		"accessList":       []any{},
+	}},
+}
+
+var testReceipt = map[string]any{
+	"transactionHash":   testTxHash,
+	"status":            "0x1",
+	"gasUsed":           "0x5208",
+	"cumulativeGasUsed": "0x5208",
+	"effectiveGasPrice": "0x3b9aca00",
+	"contractAddress":   nil,
+	"logs": []any{map[string]any{
+		"address":          "0x00000000000000000000000000000000000000C3",
+		"topics":           []string{"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"},
+		"data":             "0x",
+		"blockNumber":      testBlockHex,
+		"blockHash":        "0x01",
+		"transactionHash":  testTxHash,
+		"transactionIndex": "0x0",
+		"logIndex":         "0x0",
+		"removed":          false,
+	}},
+}
+
+var testTraces = []any{map[string]any{
+	"action": map[string]any{
+		"callType": "call",
+		"from":     "0x00000000000000000000000000000000000000a1",
+		"to":       "0x00000000000000000000000000000000000000b2",
+		"gas":      "0x5208",
+		"input":    "0x",
+		"value":    "0xde0b6b3a7640000",
+	},
+	"result":              map[string]any{"gasUsed": "0x0", "output": "0x"},
+	"blockHash":           "0x01",
+	"blockNumber":         10,
+	"subtraces":           0,
+	"traceAddress":        []int{},
+	"transactionHash":     testTxHash,
+	"transactionPosition": 0,
+	"type":                "call",
+}}
+
+// newTestRPCServer serves the few JSON-RPC methods RPCReader uses from canned
+// responses. When blockReceipts is false, eth_getBlockReceipts answers with a
+// method-not-found error to exercise the per-transaction fallback.
+func newTestRPCServer(t *testing.T, blockReceipts bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := json.NewDecoder(r.Body)
+		var raw json.RawMessage
+		if err := body.Decode(&raw); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		var reqs []map[string]any
+		batch := len(raw) > 0 && raw[0] == '['
+		if batch {
+			json.Unmarshal(raw, &reqs)
+		} else {
+			var req map[string]any
+			json.Unmarshal(raw, &req)
+			reqs = append(reqs, req)
+		}
+
+		var resps []map[string]any
+		for _, req := range reqs {
+			resp := map[string]any{"jsonrpc": "2.0", "id": req["id"]}
+			switch req["method"] {
+			case "eth_blockNumber":
+				resp["result"] = "0x14"
+			case "eth_getBlockByNumber":
+				if req["params"].([]any)[0] == testBlockHex {
+					resp["result"] = testBlock
+				} else {
+					resp["result"] = nil
+				}
+			case "eth_getBlockReceipts":
+				if blockReceipts {
+					resp["result"] = []any{testReceipt}
+				} else {
+					resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+				}
+			case "eth_getTransactionReceipt":
+				resp["result"] = testReceipt
+			case "trace_block":
+				resp["result"] = testTraces
+			default:
+				resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+			}
+			resps = append(resps, resp)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if batch {
+			json.NewEncoder(w).Encode(resps)
+		} else {
+			json.NewEncoder(w).Encode(resps[0])
+		}
+	}))
+}
+
+func TestRPCReaderBlockData(t *testing.T) {
+	for _, blockReceipts := range []bool{true, false} {
+		server := newTestRPCServer(t, blockReceipts)
+		defer server.Close()
+
+		ctx := context.Background()
+		reader, err := NewRPCReader(ctx, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+
+		data, err := reader.BlockData(ctx, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := data.Block
+		if b.Number != 10 || b.Miner != "0xab00000000000000000000000000000000000001" || b.NumOfTransactions != 1 {
+			t.Errorf("unexpected block %+v", b)
+		}
+		if want := time.Unix(0x661e5288, 0).UTC(); !b.Timestamp.Equal(want) {
+			t.Errorf("Timestamp = %v, want %v", b.Timestamp, want)
+		}
+		if b.ExtraData != "beaverbuild.org" {
+			t.Errorf("ExtraData = %q", b.ExtraData)
+		}
+
+		if len(data.Transactions) != 1 {
+			t.Fatalf("got %d transactions", len(data.Transactions))
+		}
+		tx := data.Transactions[0]
+		if tx.Value != 1e18 || tx.Status != 1 || tx.GasUsed != 21000 || tx.Type != "2" || tx.ChainID != "1" {
+			t.Errorf("unexpected transaction %+v", tx)
+		}
+
+		if len(data.Logs) != 1 || data.Logs[0].ContractAddress != "0x00000000000000000000000000000000000000c3" {
+			t.Errorf("unexpected logs %+v", data.Logs)
+		}
+
+		if len(data.Traces) != 1 {
+			t.Fatalf("got %d traces", len(data.Traces))
+		}
+		trace := data.Traces[0]
+		if trace.TraceID != "call_"+testTxHash+"_" || trace.Status != 1 || trace.TransactionStatus != 1 {
+			t.Errorf("unexpected trace %+v", trace)
+		}
+	}
+}
+
+func TestRPCReaderBlockNotFound(t *testing.T) {
+	server := newTestRPCServer(t, true)
+	defer server.Close()
+
+	ctx := context.Background()
+	reader, err := NewRPCReader(ctx, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, err := reader.Block(ctx, 11); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("got %v, want ErrBlockNotFound", err)
+	}
+	latest, err := reader.LatestBlockNumber(ctx)
+	if err != nil || latest != 20 {
+		t.Errorf("LatestBlockNumber() = %d, %v", latest, err)
+	}
+}
@@ -19,11 +19,11 @@ import (
 
 // HandlerString is the signature of the handler function that takes a
 // string as block number.
-type HandlerString func(blockNumber string, deps *utils.Deps) (bool, error)
+type HandlerString = utils.StringBlockHandlerFunc
 
 // HandlerInt64 is the signature of the handler function that takes a
 // int64 as block number.
-type HandlerInt64 func(blockNumber int64, deps *utils.Deps) (bool, error)
+type HandlerInt64 = utils.BlockHandlerFunc
 
 type DepsChecker func(*utils.Deps) error
 
@@ -130,7 +130,10 @@ func (r *EthereumBlockHandlerTestRunner) TestHandlerString(sourceSchemaName stri
 	}
 	for _, block := range blocks {
 		blockNumber := fmt.Sprintf("%d", block.Number)
-		handler(blockNumber, r.deps)
+		r.runBlock(block.Number, func(deps *utils.Deps) error {
+			_, err := handler(blockNumber, deps)
+			return err
+		})
 	}
 	for _, checker := range checkers {
 		if err := checker(r.deps); err != nil {
@@ -156,7 +159,10 @@ func (r *EthereumBlockHandlerTestRunner) TestHandlerInt64(sourceSchemaName strin
 		r.t.Fatal(err)
 	}
 	for _, block := range blocks {
-		handler(block.Number, r.deps)
+		r.runBlock(block.Number, func(deps *utils.Deps) error {
+			_, err := handler(block.Number, deps)
+			return err
+		})
 	}
 	for _, checker := range checkers {
 		if err := checker(r.deps); err != nil {
@@ -165,6 +171,15 @@ func (r *EthereumBlockHandlerTestRunner) TestHandlerInt64(sourceSchemaName strin
 	}
 }
 
+// runBlock runs a handler on one block in a block transaction, the way the
+// executor does, and fails the test if the handler returns an error.
+func (r *EthereumBlockHandlerTestRunner) runBlock(blockNumber int64, run func(*utils.Deps) error) {
+	r.t.Helper()
+	if err := r.deps.Transaction(run); err != nil {
+		r.t.Fatalf("block %d: %v", blockNumber, err)
+	}
+}
+
 func (r *EthereumBlockHandlerTestRunner) Close() {
 	r.sourceContainer.Container.Terminate(context.Background())
 	r.destContainer.Container.Terminate(context.Background())
@@ -0,0 +1,270 @@
+// Package fakedb provides a gorm db with the postgres dialect over a fake
+// database/sql driver, for unit tests of code that reads and writes through
+// gorm without a database server. See testutils for tests against a real
+// Postgres container.
+package fakedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DB records the statements run on it and answers queries with the rows set
+// with Rows; other queries return no rows, and other statements succeed and
+// affect one row.
+type DB struct {
+	*gorm.DB
+
+	mu         sync.Mutex
+	statements []Statement
+	results    []result
+	failures   []failure
+}
+
+// Statement is a statement run on a DB. Transactions are recorded as BEGIN,
+// COMMIT and ROLLBACK statements.
+type Statement struct {
+	SQL  string
+	Args []any
+}
+
+type result struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+type failure struct {
+	match string
+	err   error
+}
+
+// Open returns an empty DB.
+func Open() (*DB, error) {
+	db := &DB{}
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(connector{db})}), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		return nil, err
+	}
+	db.DB = gormDB
+	return db, nil
+}
+
+// Rows makes the queries whose SQL contains match return rows, whose values
+// are in the order of columns. The last call whose match a query contains
+// wins.
+func (db *DB) Rows(match string, columns []string, rows ...[]any) {
+	r := result{match: match, columns: columns}
+	for _, row := range rows {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			converted, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				panic(err)
+			}
+			values[i] = converted
+		}
+		r.rows = append(r.rows, values)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.results = append(db.results, r)
+}
+
+// Fail makes the statements whose SQL contains match fail with err.
+func (db *DB) Fail(match string, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.failures = append(db.failures, failure{match: match, err: err})
+}
+
+// Statements returns the statements run whose SQL contains match, in the
+// order they ran.
+func (db *DB) Statements(match string) []Statement {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var statements []Statement
+	for _, s := range db.statements {
+		if strings.Contains(s.SQL, match) {
+			statements = append(statements, s)
+		}
+	}
+	return statements
+}
+
+// Reset forgets the statements run so far.
+func (db *DB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = nil
+}
+
+// run records a statement and returns the rows it is answered with.
+func (db *DB) run(query string, args []driver.NamedValue) (*result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s := Statement{SQL: query}
+	for _, arg := range args {
+		s.Args = append(s.Args, arg.Value)
+	}
+	db.statements = append(db.statements, s)
+	for _, f := range db.failures {
+		if strings.Contains(query, f.match) {
+			return nil, f.err
+		}
+	}
+	for i := len(db.results) - 1; i >= 0; i-- {
+		if strings.Contains(query, db.results[i].match) {
+			return &db.results[i], nil
+		}
+	}
+	return &result{}, nil
+}
+
+type connector struct {
+	db *DB
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakedb: use Open")
+}
+
+type conn struct {
+	db *DB
+}
+
+var (
+	_ driver.QueryerContext    = (*conn)(nil)
+	_ driver.ExecerContext     = (*conn)(nil)
+	_ driver.ConnBeginTx       = (*conn)(nil)
+	_ driver.NamedValueChecker = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.db.run("BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return tx{c}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: r}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.db.run(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// CheckNamedValue converts the arguments that the default converter can, and
+// records the others, such as slices, as they are.
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if converted, err := driver.DefaultParameterConverter.ConvertValue(v.Value); err == nil {
+		v.Value = converted
+	}
+	return nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t tx) Commit() error {
+	_, err := t.conn.db.run("COMMIT", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.conn.db.run("ROLLBACK", nil)
+	return err
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+type rows struct {
+	result *result
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+)
+
+// Call is a contract function call, either a top level transaction or an
+// internal call read from a trace. Exactly one of Transaction and Trace is set.
+type Call struct {
+	Transaction *ethereum.Transaction
+	Trace       *ethereum.Trace
+}
+
+func (c *Call) BlockNumber() int64 {
+	if c.Trace != nil {
+		return c.Trace.BlockNumber
+	}
+	return c.Transaction.BlockNumber
+}
+
+func (c *Call) TransactionHash() string {
+	if c.Trace != nil {
+		return c.Trace.TransactionHash
+	}
+	return c.Transaction.Hash
+}
+
+func (c *Call) From() string {
+	if c.Trace != nil {
+		return c.Trace.FromAddress
+	}
+	return c.Transaction.FromAddress
+}
+
+func (c *Call) To() string {
+	if c.Trace != nil {
+		return c.Trace.ToAddress
+	}
+	return c.Transaction.ToAddress
+}
+
+func (c *Call) Input() string {
+	if c.Trace != nil {
+		return c.Trace.Input
+	}
+	return c.Transaction.Input
+}
+
+// Selector returns the lowercase 0x-prefixed function selector of the call,
+// or "" if the input is too short to hold one.
+func (c *Call) Selector() string {
+	input := c.Input()
+	if len(input) < 10 {
+		return ""
+	}
+	return strings.ToLower(input[:10])
+}
+
+// Success reports whether the call and, for internal calls, its transaction
+// did not revert.
+func (c *Call) Success() bool {
+	if c.Trace != nil {
+		return c.Trace.Status == 1 && c.Trace.TransactionStatus == 1
+	}
+	return c.Transaction.Status == 1
+}
+
+// CallHandlerFunc is the signature of call handlers.
+type CallHandlerFunc func(call *Call, deps *Deps) (bool, error)
+
+// CallMatcher selects the call handlers a call should be dispatched to.
+type CallMatcher struct {
+	handlers []compiledCallHandler
+	traces   bool
+}
+
+type compiledCallHandler struct {
+	configs.CallHandler
+	selector string
+	to       map[string]bool
+}
+
+// NewCallMatcher compiles the call handlers of a pipeline. defaultTo is used
+// for handlers without their own To list, normally Source.Addresses.
+func NewCallMatcher(handlers []configs.CallHandler, defaultTo []string) (*CallMatcher, error) {
+	m := &CallMatcher{}
+	for _, h := range handlers {
+		selector, err := configs.FunctionSelector(h.Function)
+		if err != nil {
+			return nil, fmt.Errorf("call handler %s: %w", h.Handler, err)
+		}
+		to := h.To
+		if len(to) == 0 {
+			to = defaultTo
+		}
+		c := compiledCallHandler{CallHandler: h, selector: selector}
+		if len(to) > 0 {
+			c.to = make(map[string]bool, len(to))
+			for _, address := range to {
+				c.to[strings.ToLower(address)] = true
+			}
+		}
+		m.handlers = append(m.handlers, c)
+		m.traces = m.traces || h.IncludeInternalCalls
+	}
+	return m, nil
+}
+
+// NeedsTraces reports whether any handler wants internal calls.
+func (m *CallMatcher) NeedsTraces() bool {
+	return m.traces
+}
+
+// Match returns the handlers the call should be dispatched to, in config order.
+func (m *CallMatcher) Match(call *Call) []configs.CallHandler {
+	selector := call.Selector()
+	if selector == "" {
+		return nil
+	}
+	var matched []configs.CallHandler
+	for _, h := range m.handlers {
+		if h.selector != selector {
+			continue
+		}
+		if call.Trace != nil && !h.IncludeInternalCalls {
+			continue
+		}
+		if h.to != nil && !h.to[strings.ToLower(call.To())] {
+			continue
+		}
+		if h.SuccessOnly && !call.Success() {
+			continue
+		}
+		matched = append(matched, h.CallHandler)
+	}
+	return matched
+}
+
+// NewCalls builds the calls of a block from its transactions and traces.
+// Only internal call traces are kept, since the top level call of each trace
+// tree is the transaction itself.
+func NewCalls(txs []*ethereum.Transaction, traces []*ethereum.Trace) []*Call {
+	calls := make([]*Call, 0, len(txs))
+	for _, tx := range txs {
+		calls = append(calls, &Call{Transaction: tx})
+	}
+	for _, trace := range traces {
+		if trace.TraceType == "call" && len(trace.TraceAddress) > 0 {
+			calls = append(calls, &Call{Trace: trace})
+		}
+	}
+	return calls
+}
+
+// BlockCalls reads the calls of a block from the transactions and, when
+// withTraces is set, traces tables of the source schema. Only internal call
+// traces are returned from traces, since the top level call of each trace
+// tree is the transaction itself.
+func (d *Deps) BlockCalls(blockNumber int64, withTraces bool) ([]*Call, error) {
+	schema := d.Config.GetSourceSchema()
+
+	var txs []*ethereum.Transaction
+	err := d.SourceDB.Table(schema+".transactions").
+		Where("block_number = ?", blockNumber).
+		Order("transaction_index").
+		Find(&txs).Error
+	if err != nil {
+		return nil, err
+	}
+	if !withTraces {
+		return NewCalls(txs, nil), nil
+	}
+
+	var traces []*ethereum.Trace
+	err = d.SourceDB.Table(schema+".traces").
+		Where("block_number = ? AND trace_type = ? AND cardinality(trace_address) > 0", blockNumber, "call").
+		Order("transaction_index, trace_index").
+		Find(&traces).Error
+	if err != nil {
+		return nil, err
+	}
+	return NewCalls(txs, traces), nil
+}
+
+// DispatchCalls runs the call handlers matched by m for every call of a
+// block. Handlers are looked up by name in Handlers. It stops at the first
+// handler error.
+func (d *Deps) DispatchCalls(m *CallMatcher, blockNumber int64) error {
+	calls, err := d.BlockCalls(blockNumber, m.NeedsTraces())
+	if err != nil {
+		return err
+	}
+	_, err = d.RunCallHandlers(m, calls, nil)
+	return err
+}
+
+// RunCallHandlers runs the call handlers matched by m for each of calls, in
+// order, and returns how many reported acting on their call. Handlers for
+// which skip, if not nil, returns true are not run. It stops at the first
+// handler error.
+func (d *Deps) RunCallHandlers(m *CallMatcher, calls []*Call, skip func(handler string) bool) (int, error) {
+	handled := 0
+	for _, call := range calls {
+		for _, h := range m.Match(call) {
+			if skip != nil && skip(h.Handler) {
+				continue
+			}
+			handler, err := d.Handlers.Call(h.Handler)
+			if err != nil {
+				return handled, err
+			}
+			ok, err := handler(call, d.WithHandler(h.Handler))
+			if err != nil {
+				return handled, &HandlerError{Kind: KindCall, Name: h.Handler, At: call.TransactionHash(), Err: err}
+			}
+			if ok {
+				handled++
+			}
+		}
+	}
+	return handled, nil
+}
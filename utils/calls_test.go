@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+)
+
+const (
+	testToken = "0x00000000000000000000000000000000000000aa"
+	// transfer(address,uint256)
+	testTransferInput = "0xa9059cbb000000000000000000000000000000000000000000000000000000000000000b"
+)
+
+func TestCallMatcher(t *testing.T) {
+	m, err := NewCallMatcher([]configs.CallHandler{
+		{Function: "transfer(address to, uint amount)", Handler: "HandleTransfer", SuccessOnly: true},
+		{Function: "transfer(address,uint256)", Handler: "HandleInternalTransfer", IncludeInternalCalls: true},
+	}, []string{"0x00000000000000000000000000000000000000AA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.NeedsTraces() {
+		t.Errorf("NeedsTraces() = false")
+	}
+
+	tests := []struct {
+		name string
+		call *Call
+		want []string
+	}{
+		{"successful transaction", &Call{Transaction: &ethereum.Transaction{ToAddress: testToken, Input: testTransferInput, Status: 1}}, []string{"HandleTransfer", "HandleInternalTransfer"}},
+		{"reverted transaction", &Call{Transaction: &ethereum.Transaction{ToAddress: testToken, Input: testTransferInput}}, []string{"HandleInternalTransfer"}},
+		{"other contract", &Call{Transaction: &ethereum.Transaction{ToAddress: "0x01", Input: testTransferInput, Status: 1}}, nil},
+		{"other function", &Call{Transaction: &ethereum.Transaction{ToAddress: testToken, Input: "0x095ea7b3", Status: 1}}, nil},
+		{"internal call", &Call{Trace: &ethereum.Trace{ToAddress: testToken, Input: testTransferInput, Status: 1, TransactionStatus: 1}}, []string{"HandleInternalTransfer"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, h := range m.Match(tt.call) {
+			got = append(got, h.Handler)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
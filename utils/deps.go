@@ -1,16 +1,21 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"plugin"
+	"sort"
 
 	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
 	"github.com/Zettablock/zsource/dao/evm"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Deps struct {
@@ -19,11 +24,51 @@ type Deps struct {
 	DestinationDBSchema string
 	MetadataDB          *gorm.DB
 	Logger              *slog.Logger
-	Handlers            map[string]plugin.Symbol
-	TemplateHandlers    map[string]plugin.Symbol
-	Config              *configs.Config
+	// Handlers resolves the handler names of the pipeline config, for both
+	// pipeline and template handlers.
+	Handlers *Registry
+	// TemplateHandlers are template handlers looked up in a plugin by name.
+	// They are added to Handlers by RegisterTemplateHandlers.
+	//
+	// Deprecated: register template handlers in Handlers.
+	TemplateHandlers map[string]plugin.Symbol
+	Config           *configs.Config
+	// Block is the block being processed, set on the Deps handlers get.
+	Block *ethereum.Block
 }
 
+// RegisterTemplateHandlers adds the handlers of the deprecated
+// TemplateHandlers to Handlers, creating it if it is nil. Names already in
+// Handlers are kept as they are. executor.New calls it.
+func (d *Deps) RegisterTemplateHandlers() error {
+	if len(d.TemplateHandlers) == 0 {
+		return nil
+	}
+	if d.Handlers == nil {
+		d.Handlers = NewRegistry()
+	}
+	names := make([]string, 0, len(d.TemplateHandlers))
+	for name := range d.TemplateHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if _, ok := d.Handlers.handlers[name]; ok {
+			continue
+		}
+		if err := d.Handlers.Register(name, d.TemplateHandlers[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SaveTemplate registers address with template name, whose handlers listen
+// to it from the next block on. The registration records the block being
+// processed and the pipeline, so that it is reverted if the pipeline rolls
+// the block back. Pipelines register addresses of their own; registering an
+// address again in the pipeline keeps the first registration.
 func (d *Deps) SaveTemplate(name string, address string) error {
 	templates := d.Config.PipelineConfig.Templates
 	template := findTemplate(name, templates)
@@ -31,6 +76,10 @@ func (d *Deps) SaveTemplate(name string, address string) error {
 		return fmt.Errorf("template not found: %s", name)
 	}
 
+	var blockNumber int64
+	if d.Block != nil {
+		blockNumber = d.Block.Number
+	}
 	arr := []evm.Template{}
 
 	handlers := template.EventHandlers
@@ -39,11 +88,13 @@ func (d *Deps) SaveTemplate(name string, address string) error {
 			Name:            name,
 			ContractAddress: address,
 			EventName:       handler.Event,
+			Pipeline:        d.Config.GetPipelineName(),
+			BlockNumber:     blockNumber,
 		}
 		arr = append(arr, t)
 	}
 
-	if err := d.MetadataDB.Save(&arr).Error; err != nil {
+	if err := d.MetadataDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&arr).Error; err != nil {
 		return err
 	}
 
@@ -60,21 +111,19 @@ func findTemplate(name string, templates []configs.Template) *configs.Template {
 }
 
 func (d *Deps) LoadABIByName(name string) (abi.ABI, error) {
-	wd, err := os.Getwd()
+	dir, err := d.ABIDir()
 	if err != nil {
 		return abi.ABI{}, err
 	}
 
-	pluginsDir := fmt.Sprintf("plugins_%s", d.Config.ProjectConfig.Name)
-
-	file, err := os.Open(fmt.Sprintf("%s/%s/abis/%s", wd, pluginsDir, name))
-	if err != nil {
-		return abi.ABI{}, err
-	}
+	return configs.LoadABIFile(filepath.Join(dir, name))
+}
 
-	contractAbi, err := abi.JSON(file)
+// ABIDir returns the abis directory of the project's plugins.
+func (d *Deps) ABIDir() (string, error) {
+	wd, err := os.Getwd()
 	if err != nil {
-		return abi.ABI{}, err
+		return "", err
 	}
-	return contractAbi, nil
+	return filepath.Join(wd, d.Config.GetPluginsDir(), "abis"), nil
 }
@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"plugin"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+)
+
+// BlockHandlerFunc is the signature of block handlers.
+//
+// All handlers but initialization handlers return (bool, error). The bool
+// reports whether the handler acted on its input, e.g. wrote rows, and is
+// only used for logging and metrics. A non-nil error fails the whole block:
+// the writes of every handler of the block are rolled back (see
+// Deps.Transaction) and the checkpoint is not advanced.
+type BlockHandlerFunc func(blockNumber int64, deps *Deps) (bool, error)
+
+// StringBlockHandlerFunc is the older block handler signature taking the block
+// number as a string. The registry adapts it to a BlockHandlerFunc.
+//
+// Deprecated: use BlockHandlerFunc.
+type StringBlockHandlerFunc func(blockNumber string, deps *Deps) (bool, error)
+
+// EventHandlerFunc is the signature of event handlers.
+type EventHandlerFunc func(log *ethereum.Log, deps *Deps) (bool, error)
+
+// InitializationHandlerFunc is the signature of initialization handlers.
+type InitializationHandlerFunc func(deps *Deps) error
+
+// HandlerKind is the kind of a handler, which decides the signature it should
+// have.
+type HandlerKind string
+
+const (
+	KindBlock          HandlerKind = "block"
+	KindEvent          HandlerKind = "event"
+	KindCall           HandlerKind = "call"
+	KindInterval       HandlerKind = "interval"
+	KindInitialization HandlerKind = "initialization"
+)
+
+var ErrHandlerNotFound = errors.New("handler not found")
+
+// HandlerError is returned when a handler fails. At describes what the
+// handler ran on, e.g. "block 12".
+type HandlerError struct {
+	Kind HandlerKind
+	Name string
+	At   string
+	Err  error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%s handler %s on %s: %v", e.Kind, e.Name, e.At, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// SignatureError is returned when a handler does not have the signature its
+// kind requires.
+type SignatureError struct {
+	Name string
+	Kind HandlerKind
+	Got  string
+	Want string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("%s handler %s has type %s, want %s", e.Kind, e.Name, e.Got, e.Want)
+}
+
+var handlerTypes = []reflect.Type{
+	reflect.TypeOf(BlockHandlerFunc(nil)),
+	reflect.TypeOf(StringBlockHandlerFunc(nil)),
+	reflect.TypeOf(EventHandlerFunc(nil)),
+	reflect.TypeOf(CallHandlerFunc(nil)),
+	reflect.TypeOf(IntervalHandlerFunc(nil)),
+	reflect.TypeOf(InitializationHandlerFunc(nil)),
+}
+
+// Registry maps the handler names used in pipeline configs to handler
+// functions. Handlers are stored as registered and adapted to the signature of
+// their kind on lookup, so a function can be registered before it is known
+// whether it is used as, say, a block or an event handler.
+//
+// A handler is a function with one of the handler signatures, a value of one
+// of the handler func types, or a pointer to either, which is what
+// plugin.Lookup returns for variables.
+type Registry struct {
+	handlers map[string]any
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]any{}}
+}
+
+// Register adds a handler under name. It fails if name is taken or handler is
+// not a function with a handler signature.
+func (r *Registry) Register(name string, handler any) error {
+	if _, ok := r.handlers[name]; ok {
+		return fmt.Errorf("handler %s is registered twice", name)
+	}
+	fn, ok := handlerFunc(handler)
+	if !ok {
+		return fmt.Errorf("handler %s has type %T, which is not a handler signature", name, handler)
+	}
+	for _, t := range handlerTypes {
+		if fn.Type().ConvertibleTo(t) {
+			r.handlers[name] = handler
+			return nil
+		}
+	}
+	return fmt.Errorf("handler %s has type %s, which is not a handler signature", name, fn.Type())
+}
+
+// Names returns the registered handler names, sorted.
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Block returns the block handler registered under name. Handlers with the
+// deprecated StringBlockHandlerFunc signature are adapted.
+func (r *Registry) Block(name string) (BlockHandlerFunc, error) {
+	symbol, err := r.lookup(KindBlock, name)
+	if err != nil {
+		return nil, err
+	}
+	if handler, err := adapt[BlockHandlerFunc](KindBlock, name, symbol); err == nil {
+		return handler, nil
+	}
+	handler, err := adapt[StringBlockHandlerFunc](KindBlock, name, symbol)
+	if err != nil {
+		err.(*SignatureError).Want = signature(reflect.TypeOf(BlockHandlerFunc(nil)))
+		return nil, err
+	}
+	return func(blockNumber int64, deps *Deps) (bool, error) {
+		return handler(strconv.FormatInt(blockNumber, 10), deps)
+	}, nil
+}
+
+// Event returns the event handler registered under name.
+func (r *Registry) Event(name string) (EventHandlerFunc, error) {
+	symbol, err := r.lookup(KindEvent, name)
+	if err != nil {
+		return nil, err
+	}
+	return adapt[EventHandlerFunc](KindEvent, name, symbol)
+}
+
+// Call returns the call handler registered under name.
+func (r *Registry) Call(name string) (CallHandlerFunc, error) {
+	symbol, err := r.lookup(KindCall, name)
+	if err != nil {
+		return nil, err
+	}
+	return adapt[CallHandlerFunc](KindCall, name, symbol)
+}
+
+// Interval returns the interval handler registered under name.
+func (r *Registry) Interval(name string) (IntervalHandlerFunc, error) {
+	symbol, err := r.lookup(KindInterval, name)
+	if err != nil {
+		return nil, err
+	}
+	return adapt[IntervalHandlerFunc](KindInterval, name, symbol)
+}
+
+// Initialization returns the initialization handler registered under name.
+func (r *Registry) Initialization(name string) (InitializationHandlerFunc, error) {
+	symbol, err := r.lookup(KindInitialization, name)
+	if err != nil {
+		return nil, err
+	}
+	return adapt[InitializationHandlerFunc](KindInitialization, name, symbol)
+}
+
+// Check resolves every handler referenced by the pipeline config and returns
+// all missing handlers and signature mismatches at once.
+func (r *Registry) Check(cfg *configs.Config) error {
+	var errs []error
+	for _, ref := range handlerRefs(cfg) {
+		var err error
+		switch ref.kind {
+		case KindBlock:
+			_, err = r.Block(ref.name)
+		case KindEvent:
+			_, err = r.Event(ref.name)
+		case KindCall:
+			_, err = r.Call(ref.name)
+		case KindInterval:
+			_, err = r.Interval(ref.name)
+		case KindInitialization:
+			_, err = r.Initialization(ref.name)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registry) lookup(kind HandlerKind, name string) (any, error) {
+	if r == nil {
+		return nil, fmt.Errorf("%w: %s handler %s", ErrHandlerNotFound, kind, name)
+	}
+	symbol, ok := r.handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s handler %s", ErrHandlerNotFound, kind, name)
+	}
+	return symbol, nil
+}
+
+// LoadPlugin opens the Go plugin at path and registers every handler the
+// pipeline config references. Symbols are looked up by handler name.
+func LoadPlugin(path string, cfg *configs.Config) (*Registry, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRegistry()
+	var errs []error
+	for _, ref := range handlerRefs(cfg) {
+		if _, ok := r.handlers[ref.name]; ok {
+			continue
+		}
+		symbol, err := p.Lookup(ref.name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s handler %s in %s", ErrHandlerNotFound, ref.kind, ref.name, path))
+			continue
+		}
+		if err := r.Register(ref.name, symbol); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+var (
+	staticMu sync.Mutex
+	static   = NewRegistry()
+)
+
+// Register adds a handler to the static registry, for binaries built without
+// Go plugins. It is meant to be called from init functions and panics if the
+// name is taken or the handler has no handler signature.
+func Register(name string, handler any) {
+	staticMu.Lock()
+	defer staticMu.Unlock()
+	if err := static.Register(name, handler); err != nil {
+		panic(err)
+	}
+}
+
+// StaticRegistry returns a registry with the handlers added by Register.
+func StaticRegistry() *Registry {
+	staticMu.Lock()
+	defer staticMu.Unlock()
+	r := NewRegistry()
+	for name, handler := range static.handlers {
+		r.handlers[name] = handler
+	}
+	return r
+}
+
+type handlerRef struct {
+	kind HandlerKind
+	name string
+}
+
+// handlerRefs lists the handlers a pipeline config references, in config
+// order.
+func handlerRefs(cfg *configs.Config) []handlerRef {
+	p := cfg.PipelineConfig
+	var refs []handlerRef
+	for _, name := range p.Initialization.InitializationHandlers {
+		refs = append(refs, handlerRef{KindInitialization, name})
+	}
+	for _, h := range p.BlockHandlers {
+		refs = append(refs, handlerRef{KindBlock, h.Handler})
+	}
+	for _, h := range p.EventHandlers {
+		refs = append(refs, handlerRef{KindEvent, h.Handler})
+	}
+	for _, t := range p.Templates {
+		for _, h := range t.EventHandlers {
+			refs = append(refs, handlerRef{KindEvent, h.Handler})
+		}
+	}
+	for _, h := range p.CallHandlers {
+		refs = append(refs, handlerRef{KindCall, h.Handler})
+	}
+	for _, h := range p.IntervalHandlers {
+		refs = append(refs, handlerRef{KindInterval, h.Handler})
+	}
+	return refs
+}
+
+// handlerFunc dereferences handler down to a non-nil function value.
+func handlerFunc(handler any) (reflect.Value, bool) {
+	v := reflect.ValueOf(handler)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+// adapt converts a registered handler to the func type T. Unnamed functions
+// and other named types with the same signature are converted.
+func adapt[T any](kind HandlerKind, name string, handler any) (T, error) {
+	var zero T
+	want := reflect.TypeOf(zero)
+	fn, ok := handlerFunc(handler)
+	if !ok || !fn.Type().ConvertibleTo(want) {
+		return zero, &SignatureError{Name: name, Kind: kind, Got: fmt.Sprintf("%T", handler), Want: signature(want)}
+	}
+	return fn.Convert(want).Interface().(T), nil
+}
+
+// signature renders the unnamed function type underlying t.
+func signature(t reflect.Type) string {
+	in := make([]reflect.Type, t.NumIn())
+	for i := range in {
+		in[i] = t.In(i)
+	}
+	out := make([]reflect.Type, t.NumOut())
+	for i := range out {
+		out[i] = t.Out(i)
+	}
+	return reflect.FuncOf(in, out, t.IsVariadic()).String()
+}
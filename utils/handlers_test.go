@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"errors"
+	"plugin"
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+)
+
+func handleBlock(blockNumber int64, deps *Deps) (bool, error) {
+	return blockNumber == 2, nil
+}
+
+func handleBlockString(blockNumber string, deps *Deps) (bool, error) {
+	return blockNumber == "2", nil
+}
+
+func handleLog(log *ethereum.Log, deps *Deps) (bool, error) {
+	return true, nil
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := NewRegistry()
+	event := EventHandlerFunc(handleLog)
+	for name, handler := range map[string]any{
+		"HandleBlock":       handleBlock,
+		"HandleBlockString": handleBlockString,
+		"HandleLog":         &event,
+	} {
+		if err := r.Register(name, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"HandleBlock", "HandleBlockString"} {
+		handler, err := r.Block(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := handler(2, nil); !ok {
+			t.Errorf("%s(2) = false", name)
+		}
+	}
+	if _, err := r.Event("HandleLog"); err != nil {
+		t.Error(err)
+	}
+
+	var sigErr *SignatureError
+	if _, err := r.Event("HandleBlock"); !errors.As(err, &sigErr) {
+		t.Errorf("Event(HandleBlock) error = %v, want SignatureError", err)
+	} else if !strings.Contains(err.Error(), "want func(*ethereum.Log, *utils.Deps) (bool, error)") {
+		t.Errorf("Event(HandleBlock) error = %v", err)
+	}
+	if _, err := r.Block("HandleLog"); !errors.As(err, &sigErr) {
+		t.Errorf("Block(HandleLog) error = %v, want SignatureError", err)
+	}
+	if _, err := r.Call("Missing"); !errors.Is(err, ErrHandlerNotFound) {
+		t.Errorf("Call(Missing) error = %v, want ErrHandlerNotFound", err)
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register("HandleBlock", handleBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("HandleBlock", handleBlock); err == nil {
+		t.Error("registering a name twice should fail")
+	}
+	if err := r.Register("NotAHandler", func(int) {}); err == nil {
+		t.Error("registering a non-handler function should fail")
+	}
+	if err := r.Register("NotAFunction", 1); err == nil {
+		t.Error("registering a non-function should fail")
+	}
+}
+
+func TestRegistryCheck(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register("HandleBlock", handleBlock); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &configs.Config{PipelineConfig: configs.PipelineConfig{
+		BlockHandlers: []configs.BlockHandler{{Handler: "HandleBlock"}},
+		EventHandlers: []configs.EventHandler{{Event: "Transfer(address,address,uint256)", Handler: "HandleBlock"}},
+		Templates: []configs.Template{{
+			Name:          "Pool",
+			EventHandlers: []configs.EventHandler{{Event: "Swap()", Handler: "HandleSwap"}},
+		}},
+	}}
+	err := r.Check(cfg)
+	if err == nil {
+		t.Fatal("Check() = nil")
+	}
+	var sigErr *SignatureError
+	if !errors.As(err, &sigErr) || sigErr.Name != "HandleBlock" || sigErr.Kind != KindEvent {
+		t.Errorf("Check() = %v, want a signature error for event handler HandleBlock", err)
+	}
+	if !errors.Is(err, ErrHandlerNotFound) || !strings.Contains(err.Error(), "HandleSwap") {
+		t.Errorf("Check() = %v, want HandleSwap not found", err)
+	}
+}
+
+func TestRegisterTemplateHandlers(t *testing.T) {
+	event := EventHandlerFunc(handleLog)
+	d := &Deps{TemplateHandlers: map[string]plugin.Symbol{"HandleSwap": &event}}
+	if err := d.RegisterTemplateHandlers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Handlers.Event("HandleSwap"); err != nil {
+		t.Errorf("Event(HandleSwap) error = %v", err)
+	}
+	// Handlers already registered are kept.
+	if err := d.RegisterTemplateHandlers(); err != nil {
+		t.Errorf("RegisterTemplateHandlers() again = %v", err)
+	}
+
+	d = &Deps{TemplateHandlers: map[string]plugin.Symbol{"NotAHandler": func(int) {}}}
+	if err := d.RegisterTemplateHandlers(); err == nil {
+		t.Error("RegisterTemplateHandlers() with a non-handler succeeded")
+	}
+}
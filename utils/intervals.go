@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/dao/metadata"
+)
+
+// IntervalTick describes the interval an interval handler runs for. Block is
+// the first processed block of the interval; StartBlock or StartTime, for
+// block and time based handlers respectively, is where the interval begins.
+type IntervalTick struct {
+	Block      *ethereum.Block
+	Bucket     int64
+	StartBlock int64
+	StartTime  time.Time
+}
+
+// IntervalHandlerFunc is the signature of interval handlers.
+type IntervalHandlerFunc func(tick *IntervalTick, deps *Deps) (bool, error)
+
+// IntervalScheduler runs the interval handlers of a pipeline. The intervals
+// each handler ran for are kept in the interval_states metadata table, so a
+// handler runs once per interval across restarts as long as blocks are
+// processed in order. The rows of the blocks within the reorg depth are kept,
+// so that a rollback can delete the rows after its ancestor block and leave
+// the state the handlers had at that block.
+type IntervalScheduler struct {
+	handlers []configs.IntervalHandler
+	// last caches the interval_states rows, keyed by stateKey. It is loaded
+	// on the first Run.
+	last map[string]int64
+}
+
+func NewIntervalScheduler(handlers []configs.IntervalHandler) *IntervalScheduler {
+	return &IntervalScheduler{handlers: handlers}
+}
+
+// Run runs every handler whose next interval starts at or before block and
+// returns how many reported acting on their tick. A handler's state is only
+// advanced when it succeeds, so a failed handler runs again on the next
+// block. The handlers skip reports true for, the dead-lettered ones, do not
+// run but their state advances as if they had.
+func (s *IntervalScheduler) Run(d *Deps, block *ethereum.Block, skip func(name string) bool) (int, error) {
+	if len(s.handlers) == 0 {
+		return 0, nil
+	}
+	if s.last == nil {
+		if err := s.load(d); err != nil {
+			return 0, err
+		}
+	}
+	handled := 0
+	for _, h := range s.handlers {
+		bucket, err := h.Bucket(block.Number, block.Timestamp)
+		if err != nil {
+			return handled, fmt.Errorf("interval handler %s: %w", h.Handler, err)
+		}
+		key := stateKey(h.Handler, h.Spec())
+		if last, ok := s.last[key]; ok && bucket <= last {
+			continue
+		}
+
+		ok := false
+		if skip == nil || !skip(h.Handler) {
+			if ok, err = runInterval(d, h, block, bucket); err != nil {
+				return handled, err
+			}
+		}
+
+		state := metadata.IntervalState{
+			Project:     d.Config.GetProjectName(),
+			Pipeline:    d.Config.GetPipelineName(),
+			Handler:     h.Handler,
+			Interval:    h.Spec(),
+			LastBucket:  bucket,
+			BlockNumber: block.Number,
+			UpdatedAt:   time.Now().UTC(),
+		}
+		if err := d.MetadataDB.Save(&state).Error; err != nil {
+			return handled, err
+		}
+		if err := s.prune(d, state); err != nil {
+			return handled, err
+		}
+		s.last[key] = bucket
+		if ok {
+			handled++
+		}
+	}
+	return handled, nil
+}
+
+// Replay runs the handler called name on the interval block falls into, for
+// each interval the handler has, without reading or advancing its state. It
+// returns how many runs reported acting on their tick.
+func (s *IntervalScheduler) Replay(d *Deps, block *ethereum.Block, name string) (int, error) {
+	handled := 0
+	for _, h := range s.handlers {
+		if h.Handler != name {
+			continue
+		}
+		bucket, err := h.Bucket(block.Number, block.Timestamp)
+		if err != nil {
+			return handled, fmt.Errorf("interval handler %s: %w", h.Handler, err)
+		}
+		ok, err := runInterval(d, h, block, bucket)
+		if err != nil {
+			return handled, err
+		}
+		if ok {
+			handled++
+		}
+	}
+	return handled, nil
+}
+
+// runInterval runs handler h on the tick of bucket.
+func runInterval(d *Deps, h configs.IntervalHandler, block *ethereum.Block, bucket int64) (bool, error) {
+	handler, err := d.Handlers.Interval(h.Handler)
+	if err != nil {
+		return false, err
+	}
+	tick := &IntervalTick{Block: block, Bucket: bucket}
+	tick.StartBlock, tick.StartTime = h.BucketStart(bucket)
+	ok, err := handler(tick, d.WithHandler(h.Handler))
+	if err != nil {
+		return false, &HandlerError{Kind: KindInterval, Name: h.Handler, At: fmt.Sprintf("block %d", block.Number), Err: err}
+	}
+	return ok, nil
+}
+
+// Reset drops the cached state so that it is read again from the metadata
+// db. It should be called after a block whose transaction rolled back.
+func (s *IntervalScheduler) Reset() {
+	s.last = nil
+}
+
+// load reads the last bucket of each handler and interval from its newest
+// row.
+func (s *IntervalScheduler) load(d *Deps) error {
+	var states []metadata.IntervalState
+	err := d.MetadataDB.
+		Where("project = ? AND pipeline = ?", d.Config.GetProjectName(), d.Config.GetPipelineName()).
+		Order("block_number").
+		Find(&states).Error
+	if err != nil {
+		return err
+	}
+	s.last = make(map[string]int64, len(states))
+	for _, state := range states {
+		s.last[stateKey(state.Handler, state.Interval)] = state.LastBucket
+	}
+	return nil
+}
+
+// stateKey is the key of the state of handler for interval.
+func stateKey(handler string, interval string) string {
+	return handler + "/" + interval
+}
+
+// prune deletes the rows of the handler and interval of state that a
+// rollback can no longer restore: those older than its newest row at or below
+// the reorg depth.
+func (s *IntervalScheduler) prune(d *Deps, state metadata.IntervalState) error {
+	final := state.BlockNumber - d.Config.PipelineConfig.Source.GetReorgDepth()
+	newest := d.MetadataDB.Model(&metadata.IntervalState{}).
+		Select("MAX(block_number)").
+		Where("project = ? AND pipeline = ? AND handler = ? AND interval = ? AND block_number <= ?",
+			state.Project, state.Pipeline, state.Handler, state.Interval, final)
+	return d.MetadataDB.
+		Where("project = ? AND pipeline = ? AND handler = ? AND interval = ? AND block_number < (?)",
+			state.Project, state.Pipeline, state.Handler, state.Interval, newest).
+		Delete(&metadata.IntervalState{}).Error
+}
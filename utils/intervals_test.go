@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/testutils/fakedb"
+)
+
+func TestIntervalSchedulerState(t *testing.T) {
+	db, err := fakedb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rows of blocks 20 and 35 are kept for a rollback; the newest is the
+	// state of the handler every 10 blocks. The handler has its own state
+	// every 25 blocks, and a state of an interval it no longer has.
+	db.Rows(`FROM "interval_states"`, []string{"project", "pipeline", "handler", "interval", "block_number", "last_bucket"},
+		[]any{"project", "pipeline", "HandlePeriodic", "everyBlocks 10", 20, 2},
+		[]any{"project", "pipeline", "HandlePeriodic", "everyBlocks 25", 25, 1},
+		[]any{"project", "pipeline", "HandlePeriodic", "everyBlocks 10", 35, 3},
+		[]any{"project", "pipeline", "HandlePeriodic", "everyBlocks 5", 38, 7},
+	)
+	var ticks []int64
+	registry := NewRegistry()
+	err = registry.Register("HandlePeriodic", func(tick *IntervalTick, deps *Deps) (bool, error) {
+		ticks = append(ticks, tick.Bucket)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &configs.Config{
+		ProjectConfig:  configs.ProjectConfig{Name: "project"},
+		PipelineConfig: configs.PipelineConfig{Name: "pipeline", Source: configs.Source{ReorgDepth: 10}},
+	}
+	d := &Deps{MetadataDB: db.DB, Handlers: registry, Config: cfg}
+	s := NewIntervalScheduler([]configs.IntervalHandler{
+		{Handler: "HandlePeriodic", EveryBlocks: 10},
+		{Handler: "HandlePeriodic", EveryBlocks: 25},
+	})
+
+	for _, number := range []int64{39, 40, 41} {
+		if _, err := s.Run(d, &ethereum.Block{Number: number, Timestamp: time.Unix(number, 0)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(ticks, []int64{4}) {
+		t.Errorf("handler ran for buckets %v, want [4]", ticks)
+	}
+
+	// Save updates the row of the block, or inserts it if there is none.
+	saved := db.Statements(`UPDATE "interval_states"`)
+	if len(saved) != 1 {
+		t.Fatalf("Run() saved %d states, want 1", len(saved))
+	}
+	if args := saved[0].Args; args[0] != int64(4) || args[5] != "everyBlocks 10" || args[6] != int64(40) {
+		t.Errorf("saved state %v, want block 40 bucket 4", args)
+	}
+	pruned := db.Statements(`DELETE FROM "interval_states"`)
+	if len(pruned) != 1 {
+		t.Fatalf("Run() pruned %d times, want 1", len(pruned))
+	}
+	if q := pruned[0]; !strings.Contains(q.SQL, "block_number < (SELECT MAX(block_number)") || q.Args[len(q.Args)-1] != int64(30) {
+		t.Errorf("prune = %s %v, want the rows before the newest at or below block 30", q.SQL, q.Args)
+	}
+}
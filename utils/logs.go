@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+	"github.com/Zettablock/zsource/source"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// EventLogs reads the logs of blocks fromBlock to toBlock, inclusive, that
+// match the event of handler and its argument filter, from the logs table of
+// the source schema. Only logs of the given contract addresses are returned,
+// unless addresses is empty. The filter is applied by Postgres on the topics
+// column, so non-matching logs are never read, as the executor does when it
+// reads the logs of a block, see source.LogFilterer.
+func (d *Deps) EventLogs(contractAbi abi.ABI, handler configs.EventHandler, addresses []string, fromBlock int64, toBlock int64) ([]*ethereum.Log, error) {
+	event, err := configs.FindEvent(contractAbi, handler.Event)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := configs.CompileFilter(event, handler.Filter)
+	if err != nil {
+		return nil, err
+	}
+	where, args := source.LogQuery{Addresses: addresses, Topics: topics}.Where()
+
+	var logs []*ethereum.Log
+	err = d.SourceDB.Table(d.Config.GetSourceSchema()+".logs").
+		Where("block_number BETWEEN ? AND ?", fromBlock, toBlock).
+		Where(where, args...).
+		Order("block_number, log_index").
+		Find(&logs).Error
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/Zettablock/zsource/configs"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const provenancePlugin = "zsource:provenance"
+
+type provenanceKey struct{}
+
+// Provenance records which block, pipeline and handler wrote a destination
+// row. Models embed it to opt in: the rows they create, save or update
+// through the DestinationDB of the Deps a handler gets are stamped with it,
+// once the db uses ProvenancePlugin. The entity declaring the table should
+// set Provenance, so that the table has the columns. Rows written with raw
+// SQL are not stamped.
+//
+// Only the last write is recorded: a row created for one block and updated
+// for a later one carries the later block, and rolling back that block
+// deletes the row rather than restoring it, see destination.DeleteBlocks.
+type Provenance struct {
+	ProvenanceBlock    int64  `gorm:"column:provenance_block" json:"provenance_block"`
+	ProvenanceHash     string `gorm:"column:provenance_hash" json:"provenance_hash"`
+	ProvenancePipeline string `gorm:"column:provenance_pipeline" json:"provenance_pipeline"`
+	ProvenanceHandler  string `gorm:"column:provenance_handler" json:"provenance_handler"`
+}
+
+// WithHandler returns a copy of d for running handler on d.Block, whose
+// DestinationDB stamps the rows written through it with their Provenance. It
+// returns d itself when there is no block or no destination db.
+func (d *Deps) WithHandler(handler string) *Deps {
+	if d.Block == nil || d.DestinationDB == nil {
+		return d
+	}
+	ctx := d.DestinationDB.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p := &Provenance{
+		ProvenanceBlock:    d.Block.Number,
+		ProvenanceHash:     d.Block.Hash,
+		ProvenancePipeline: d.Config.GetPipelineName(),
+		ProvenanceHandler:  handler,
+	}
+	c := *d
+	c.DestinationDB = d.DestinationDB.WithContext(context.WithValue(ctx, provenanceKey{}, p))
+	return &c
+}
+
+// ProvenancePlugin is the gorm plugin stamping Provenance on the rows written
+// through the Deps of handlers.
+type ProvenancePlugin struct{}
+
+func (ProvenancePlugin) Name() string {
+	return provenancePlugin
+}
+
+func (ProvenancePlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Create().Before("gorm:create").Register(provenancePlugin, stampProvenance); err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register(provenancePlugin, stampProvenance)
+}
+
+// UseProvenance makes db use ProvenancePlugin, unless it already does.
+func UseProvenance(db *gorm.DB) error {
+	if _, ok := db.Config.Plugins[provenancePlugin]; ok {
+		return nil
+	}
+	return db.Use(ProvenancePlugin{})
+}
+
+func stampProvenance(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Context == nil {
+		return
+	}
+	p, ok := stmt.Context.Value(provenanceKey{}).(*Provenance)
+	if !ok {
+		return
+	}
+	values := map[string]any{
+		configs.ProvenanceBlockColumn:    p.ProvenanceBlock,
+		configs.ProvenanceHashColumn:     p.ProvenanceHash,
+		configs.ProvenancePipelineColumn: p.ProvenancePipeline,
+		configs.ProvenanceHandlerColumn:  p.ProvenanceHandler,
+	}
+	for column, value := range values {
+		field := stmt.Schema.LookUpField(column)
+		if field == nil {
+			continue
+		}
+		switch dest := stmt.Dest.(type) {
+		case map[string]any:
+			dest[column] = value
+		case []map[string]any:
+			for _, row := range dest {
+				row[column] = value
+			}
+		default:
+			rv := reflect.Indirect(reflect.ValueOf(dest))
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Array:
+				for i := 0; i < rv.Len(); i++ {
+					stampField(db, field, reflect.Indirect(rv.Index(i)), value)
+				}
+			case reflect.Struct:
+				stampField(db, field, rv, value)
+			}
+		}
+	}
+}
+
+func stampField(db *gorm.DB, field *schema.Field, row reflect.Value, value any) {
+	if row.Type() != db.Statement.Schema.ModelType {
+		return
+	}
+	if err := field.Set(db.Statement.Context, row, value); err != nil {
+		db.AddError(err)
+	}
+}
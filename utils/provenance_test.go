@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Zettablock/zsource/configs"
+	"github.com/Zettablock/zsource/dao/ethereum"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type provenanceRow struct {
+	ID    int64 `gorm:"column:id;primaryKey"`
+	Value string
+	Provenance
+}
+
+func TestProvenance(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UseProvenance(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := UseProvenance(db); err != nil {
+		t.Errorf("second UseProvenance() = %v", err)
+	}
+	cfg := &configs.Config{PipelineConfig: configs.PipelineConfig{Name: "pipeline"}}
+	d := &Deps{DestinationDB: db, Config: cfg, Block: &ethereum.Block{Number: 7, Hash: "0x07"}}
+
+	rows := []provenanceRow{{ID: 1}, {ID: 2}}
+	stmt := d.WithHandler("HandleTransfer").DestinationDB.Create(&rows).Statement
+	want := Provenance{ProvenanceBlock: 7, ProvenanceHash: "0x07", ProvenancePipeline: "pipeline", ProvenanceHandler: "HandleTransfer"}
+	for _, row := range rows {
+		if row.Provenance != want {
+			t.Errorf("row %d provenance = %+v, want %+v", row.ID, row.Provenance, want)
+		}
+	}
+	if sql := stmt.SQL.String(); !strings.Contains(sql, `"provenance_handler"`) {
+		t.Errorf("insert %s has no provenance", sql)
+	}
+
+	stmt = d.WithHandler("HandleBlock").DestinationDB.Model(&provenanceRow{ID: 1}).Update("value", "x").Statement
+	if sql := stmt.SQL.String(); !strings.Contains(sql, `"provenance_handler"=`) {
+		t.Errorf("update %s has no provenance", sql)
+	}
+
+	// Writes outside handlers are not stamped.
+	row := provenanceRow{ID: 3}
+	db.Create(&row)
+	if row.Provenance != (Provenance{}) {
+		t.Errorf("provenance = %+v outside handlers", row.Provenance)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import "gorm.io/gorm"
+
+// Transaction runs fn with a copy of d whose DestinationDB and MetadataDB are
+// transactions, and commits them if fn returns nil and rolls them back
+// otherwise. Handlers of a block run with the copy, so a failing handler
+// leaves no partial rows behind, and neither do the templates saved or the
+// checkpoint advanced for the block.
+//
+// When DestinationDB and MetadataDB are the same *gorm.DB a single
+// transaction is used and the commit is atomic. Otherwise the destination
+// transaction commits first: if the metadata commit then fails, the block is
+// processed again rather than its checkpoint being recorded without its
+// rows.
+func (d *Deps) Transaction(fn func(tx *Deps) error) error {
+	tx := *d
+	run := func() error { return fn(&tx) }
+
+	if d.DestinationDB != nil {
+		inner := run
+		run = func() error {
+			return d.DestinationDB.Transaction(func(db *gorm.DB) error {
+				tx.DestinationDB = db
+				if d.MetadataDB == d.DestinationDB {
+					tx.MetadataDB = db
+				}
+				return inner()
+			})
+		}
+	}
+	if d.MetadataDB != nil && d.MetadataDB != d.DestinationDB {
+		inner := run
+		run = func() error {
+			return d.MetadataDB.Transaction(func(db *gorm.DB) error {
+				tx.MetadataDB = db
+				return inner()
+			})
+		}
+	}
+	return run()
+}